@@ -6,140 +6,150 @@ import (
 	"errors"
 	"fmt"
 	"io/ioutil"
+	"net/url"
 	"os"
 	"os/exec"
 	"sort"
+	"strings"
+
+	"github.com/DerGut/load-tests/secrets"
 )
 
 const (
 	// DefaultDumpFile is path to the mongodb dump to restore
 	DefaultDumpFile = "accounts/data/dump"
+	// DefaultAccountsFile is the path to the accounts file Generate writes.
+	DefaultAccountsFile = "accounts/data/accounts.json"
 
 	defaultClassName = "TestKlasse"
 	defaultPassword  = "Passwort123!"
-	accountsFile     = "accounts/data/accounts.json"
 	nsFrom           = "meteor.*"
 	nsTo             = "pearup.*"
 )
 
 var ErrWrongDumpSize = errors.New("current dump has a different size than requested")
 
-func Generate(classConcurrency, classSize int, preparedPortion float64) error {
-	accounts := make([]Classroom, classConcurrency)
-	numToPrepare := NumPrepared(classConcurrency, preparedPortion)
-	for i := 0; i < classConcurrency; i++ {
-		prepare := i < numToPrepare
-		accounts[i] = buildClassroom(classSize, i, prepare)
+// Generate writes the accounts file at path with enough teachers for the
+// class concurrency, whose classes follow the spec.
+func Generate(path string, classConcurrency int, preparedPortion float64, spec Spec) error {
+	if err := spec.Validate(); err != nil {
+		return err
+	}
+	accounts, err := spec.build(classConcurrency, NumPrepared(classConcurrency, preparedPortion))
+	if err != nil {
+		return err
 	}
 
 	b, err := json.MarshalIndent(&accounts, "", "  ")
 	if err != nil {
 		panic(fmt.Errorf("failed to marshal accounts %v", err))
 	}
-	return ioutil.WriteFile(accountsFile, b, os.ModePerm)
+	// Only the current user may read the passwords
+	return ioutil.WriteFile(path, b, 0o600)
 }
 
 func NumPrepared(classConcurrency int, preparedPortion float64) int {
 	return int(float64(classConcurrency) * preparedPortion)
 }
 
-func buildClassroom(classSize, classId int, isPrepared bool) Classroom {
-	return Classroom{
-		Prepared: isPrepared,
-		Name:     defaultClassName,
-		Teacher: Teacher{
-			Email:    fmt.Sprintf("teacher-%d@load-test.com", classId+1),
-			Password: defaultPassword,
-		},
-		Pupils: buildPupils(classSize, classId),
-	}
-}
-
-func buildPupils(number, classId int) []Pupil {
-	p := make([]Pupil, number)
-	for i := 0; i < number; i++ {
-		p[i] = Pupil{
-			Username: fmt.Sprintf("pupil%dt%d", classId+1, i+1),
-			Password: defaultPassword,
-			Company:  fmt.Sprintf("company%dt%d", classId+1, i+1),
-		}
-	}
-
-	return p
-}
-
-func Get(classConcurrency, classSize int, preparedPortion float64) ([]Classroom, error) {
-	dump, err := Read()
-	if err != nil {
-		return nil, err
-	}
-
-	if len(dump) < classConcurrency {
-		return nil, fmt.Errorf("not enough classes: %w", ErrWrongDumpSize)
-	}
-
-	accounts, err := SizeDump(dump, classConcurrency, classSize, preparedPortion)
+// Get returns the classes of the source a run of the given class
+// concurrency needs, with sizes following the distribution.
+func Get(ctx context.Context, src AccountSource, classConcurrency int, sizes SizeDistribution, preparedPortion float64) ([]Classroom, error) {
+	dump, err := Read(ctx, src)
 	if err != nil {
 		return nil, err
 	}
 
-	return accounts, nil
+	return SizeDump(dump, classConcurrency, sizes, preparedPortion)
 }
 
-func Read() ([]Classroom, error) {
-	b, err := ioutil.ReadFile(accountsFile)
+// Read returns all classes of the source. Their passwords are registered
+// as secrets, which keeps them out of the logs.
+func Read(ctx context.Context, src AccountSource) ([]Classroom, error) {
+	c, err := src.Read(ctx)
 	if err != nil {
 		return nil, err
 	}
 
-	var c []Classroom
-	err = json.Unmarshal(b, &c)
-	if err != nil {
-		panic(err)
+	for _, class := range c {
+		secrets.Register(class.Teacher.Password)
+		for _, p := range class.Pupils {
+			secrets.Register(p.Password)
+		}
 	}
-
-	return c, err
+	return c, nil
 }
 
-func SizeDump(dump []Classroom, classConcurrency, classSize int, preparedPortion float64) ([]Classroom, error) {
-	numPreparedWanted := NumPrepared(classConcurrency, preparedPortion)
+// resolvePasswords reads the passwords given as file:<path> or env:<name>.
+// Only locally written accounts may refer to local secrets like this,
+// others could make loadctl log in with them.
+func resolvePasswords(classes []Classroom) error {
+	for i := range classes {
+		c := &classes[i]
+		var err error
+		if c.Teacher.Password, err = secrets.Resolve(c.Teacher.Password); err != nil {
+			return fmt.Errorf("password of %s: %w", c.Email, err)
+		}
+		for j := range c.Pupils {
+			p := &c.Pupils[j]
+			if p.Password, err = secrets.Resolve(p.Password); err != nil {
+				return fmt.Errorf("password of %s: %w", p.Username, err)
+			}
+		}
+	}
+	return nil
+}
 
-	// Sort accounts from prepared to unprepared
-	sort.Slice(dump, func(i, j int) bool {
-		return dump[i].Prepared && !dump[j].Prepared
+// SizeDump selects the classes from the dump which satisfy the demands of
+// the size distribution and cuts them down to their size. Each bucket takes
+// the smallest classes which are large enough, so that larger ones remain
+// for larger buckets. All shortfalls are reported at once.
+func SizeDump(dump []Classroom, classConcurrency int, sizes SizeDistribution, preparedPortion float64) ([]Classroom, error) {
+	bySize := make([]int, len(dump))
+	for i := range bySize {
+		bySize[i] = i
+	}
+	sort.SliceStable(bySize, func(i, j int) bool {
+		return len(dump[bySize[i]].Pupils) < len(dump[bySize[j]].Pupils)
 	})
 
 	var accounts []Classroom
-	i := 0
-	j := 0
-	for i < classConcurrency && j < len(dump) {
-		if j < numPreparedWanted && !dump[j].Prepared {
-			return nil, fmt.Errorf("not enough prepared accounts in dump: %w", ErrWrongDumpSize)
-		}
-		if j >= numPreparedWanted && dump[j].Prepared {
-			j++
-			continue
-		}
-
-		err := sizeClassroom(&dump[j], classSize)
-		if err != nil {
-			return nil, err
+	var shortfalls []string
+	used := make([]bool, len(dump))
+	// A teacher can only hold a single class at once
+	teachers := make(map[string]bool)
+	for _, d := range sizes.Demands(classConcurrency, preparedPortion) {
+		for _, prepared := range []bool{true, false} {
+			want := d.Unprepared
+			if prepared {
+				want = d.Prepared
+			}
+
+			got := 0
+			for _, i := range bySize {
+				if got == want {
+					break
+				}
+				c := dump[i]
+				if used[i] || teachers[c.Email] || c.Prepared != prepared || len(c.Pupils) < d.Size {
+					continue
+				}
+				used[i], teachers[c.Email] = true, true
+				c.Pupils = c.Pupils[:d.Size]
+				accounts = append(accounts, c)
+				got++
+			}
+			if got < want {
+				shortfalls = append(shortfalls, fmt.Sprintf("size %d: %d of %d %s classes", d.Size, got, want, kind(prepared)))
+			}
 		}
-		accounts = append(accounts, dump[j])
-		i++
-		j++
 	}
 
-	return accounts, nil
-}
-
-func sizeClassroom(c *Classroom, classSize int) error {
-	if len(c.Pupils) < classSize {
-		return fmt.Errorf("not enough prepared accounts in classroom: %w", ErrWrongDumpSize)
+	if len(shortfalls) > 0 {
+		return nil, fmt.Errorf("not enough classes (%s): %w", strings.Join(shortfalls, ", "), ErrWrongDumpSize)
 	}
 
-	c.Pupils = c.Pupils[:classSize]
-	return nil
+	return accounts, nil
 }
 
 func Restore(ctx context.Context, dbUri, archivePath string, copyIO bool) error {
@@ -160,6 +170,16 @@ func Restore(ctx context.Context, dbUri, archivePath string, copyIO bool) error
 	return cmd.Run()
 }
 
+// Database identifies the database of the URI without any credentials, for
+// logs and errors. Accounts are leased per database.
+func Database(dbUri string) string {
+	u, err := url.Parse(dbUri)
+	if err != nil {
+		return ""
+	}
+	return u.Host + u.Path
+}
+
 type Classroom struct {
 	Prepared bool   `json:"prepared"`
 	Name     string `json:"name"`
@@ -0,0 +1,276 @@
+package accounts
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+)
+
+// Pool leases the classes of an account source to runs, so that runs against
+// the same database never use the same teacher at once. Leases are persisted
+// to a file shared by all runs on the machine and expire after a while, in
+// case a run crashed without releasing them.
+type Pool struct {
+	path     string
+	database string
+	run      string
+	ttl      time.Duration
+	classes  []Classroom
+}
+
+// Lease is a class leased to a run.
+type Lease struct {
+	Teacher  string `json:"teacher"`
+	Database string `json:"database"`
+	Run      string `json:"run"`
+	// Runner is the runner which holds the class, if it has been started yet.
+	Runner  string    `json:"runner,omitempty"`
+	Expires time.Time `json:"expires"`
+}
+
+// Criteria select the classes to lease.
+type Criteria struct {
+	Prepared bool
+	// ClassSize is the number of pupils, larger classes are cut down to it.
+	ClassSize int
+}
+
+var ErrLeased = errors.New("classes are leased by other runs")
+
+// NewPool returns the pool of the classes of src for the run against the
+// database. Its leases are recorded to the file at path and expire after ttl.
+func NewPool(ctx context.Context, src AccountSource, path, database, run string, ttl time.Duration) (*Pool, error) {
+	classes, err := Read(ctx, src)
+	if err != nil {
+		return nil, err
+	}
+
+	// Leasing the smallest classes which are large enough leaves larger ones for larger demands
+	sort.SliceStable(classes, func(i, j int) bool {
+		return len(classes[i].Pupils) < len(classes[j].Pupils)
+	})
+
+	return &Pool{path: path, database: database, run: run, ttl: ttl, classes: classes}, nil
+}
+
+// Lease leases n classes which match the criteria and which aren't leased
+// by any run yet.
+func (p *Pool) Lease(c Criteria, n int) ([]Classroom, error) {
+	var leased []Classroom
+	err := p.update(func(leases []Lease) ([]Lease, error) {
+		taken := make(map[string]bool, len(leases))
+		for _, l := range leases {
+			if l.Database == p.database {
+				taken[l.Teacher] = true
+			}
+		}
+
+		expires := time.Now().Add(p.ttl)
+		matching := 0
+		for _, class := range p.classes {
+			if len(leased) == n {
+				break
+			}
+			if class.Prepared != c.Prepared || len(class.Pupils) < c.ClassSize {
+				continue
+			}
+			matching++
+			if taken[class.Email] {
+				continue
+			}
+
+			// A teacher can only hold a single class at once
+			taken[class.Email] = true
+			class.Pupils = class.Pupils[:c.ClassSize]
+			leased = append(leased, class)
+			leases = append(leases, Lease{Teacher: class.Email, Database: p.database, Run: p.run, Expires: expires})
+		}
+
+		if len(leased) < n {
+			if matching >= n {
+				return nil, fmt.Errorf("%d of %d %s classes of size %d available: %w", len(leased), n, kind(c.Prepared), c.ClassSize, ErrLeased)
+			}
+			return nil, fmt.Errorf("only %d %s classes of size %d among the accounts: %w", matching, kind(c.Prepared), c.ClassSize, ErrWrongDumpSize)
+		}
+		return leases, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return leased, nil
+}
+
+func kind(prepared bool) string {
+	if prepared {
+		return "prepared"
+	}
+	return "unprepared"
+}
+
+// Hold records that the runner holds the classes. It does nothing on a nil *Pool.
+func (p *Pool) Hold(runner string, classes []Classroom) error {
+	if p == nil {
+		return nil
+	}
+
+	teachers := emails(classes)
+	return p.update(func(leases []Lease) ([]Lease, error) {
+		for i, l := range leases {
+			if p.owns(l) && teachers[l.Teacher] {
+				leases[i].Runner = runner
+			}
+		}
+		return leases, nil
+	})
+}
+
+// Release returns the classes to the pool, so that they can be leased
+// again. It does nothing on a nil *Pool.
+func (p *Pool) Release(classes ...Classroom) error {
+	if p == nil {
+		return nil
+	}
+
+	teachers := emails(classes)
+	return p.update(func(leases []Lease) ([]Lease, error) {
+		kept := leases[:0]
+		for _, l := range leases {
+			if !p.owns(l) || !teachers[l.Teacher] {
+				kept = append(kept, l)
+			}
+		}
+		return kept, nil
+	})
+}
+
+// Close releases all classes leased by the run.
+func (p *Pool) Close() error {
+	return p.update(func(leases []Lease) ([]Lease, error) {
+		kept := leases[:0]
+		for _, l := range leases {
+			if !p.owns(l) {
+				kept = append(kept, l)
+			}
+		}
+		return kept, nil
+	})
+}
+
+func (p *Pool) owns(l Lease) bool {
+	return l.Run == p.run && l.Database == p.database
+}
+
+func emails(classes []Classroom) map[string]bool {
+	m := make(map[string]bool, len(classes))
+	for _, c := range classes {
+		m[c.Email] = true
+	}
+	return m
+}
+
+// Leases returns all leases recorded in the file at path which haven't expired yet.
+func Leases(path string) ([]Lease, error) {
+	leases, err := readLeases(path)
+	if err != nil {
+		return nil, err
+	}
+	return unexpired(leases, time.Now()), nil
+}
+
+// update applies fn to the unexpired leases and saves the result, while
+// holding the lock on the lease file.
+func (p *Pool) update(fn func([]Lease) ([]Lease, error)) error {
+	unlock, err := lock(p.path)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	leases, err := readLeases(p.path)
+	if err != nil {
+		return err
+	}
+	leases, err = fn(unexpired(leases, time.Now()))
+	if err != nil {
+		return err
+	}
+
+	b, err := json.MarshalIndent(leases, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp := p.path + ".tmp"
+	if err := ioutil.WriteFile(tmp, b, 0o644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, p.path)
+}
+
+func readLeases(path string) ([]Lease, error) {
+	b, err := ioutil.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var leases []Lease
+	if err := json.Unmarshal(b, &leases); err != nil {
+		return nil, fmt.Errorf("malformed lease file %s: %w", path, err)
+	}
+	return leases, nil
+}
+
+func unexpired(leases []Lease, now time.Time) []Lease {
+	var active []Lease
+	for _, l := range leases {
+		if l.Expires.After(now) {
+			active = append(active, l)
+		}
+	}
+	return active
+}
+
+const (
+	lockTimeout = 30 * time.Second
+	// staleLock is the age after which a lock is considered left behind by
+	// a crashed process. Locks are only held for a single update.
+	staleLock = 1 * time.Minute
+)
+
+// lock creates a lock file next to path and returns a function removing it.
+func lock(path string) (func(), error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return nil, err
+	}
+
+	lockPath := path + ".lock"
+	deadline := time.Now().Add(lockTimeout)
+	for {
+		f, err := os.OpenFile(lockPath, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0o644)
+		if err == nil {
+			f.Close()
+			return func() { os.Remove(lockPath) }, nil
+		}
+		if !errors.Is(err, os.ErrExist) {
+			return nil, err
+		}
+
+		if info, err := os.Stat(lockPath); err == nil && time.Since(info.ModTime()) > staleLock {
+			os.Remove(lockPath)
+			continue
+		}
+		if time.Now().After(deadline) {
+			return nil, fmt.Errorf("couldn't lock %s, remove %s if no other loadctl is running", path, lockPath)
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+}
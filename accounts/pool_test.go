@@ -0,0 +1,79 @@
+package accounts
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// staticSource provides a fixed list of classes.
+type staticSource []Classroom
+
+func (s staticSource) Read(ctx context.Context) ([]Classroom, error) {
+	return append([]Classroom(nil), s...), nil
+}
+
+func (s staticSource) String() string {
+	return "static"
+}
+
+func newTestPool(t *testing.T, path, run string) *Pool {
+	src := staticSource{
+		{Prepared: true, Name: "class1", Teacher: Teacher{Email: "teacher1@test.pearup.de"}, Pupils: []Pupil{{Username: "pupil1t1"}}},
+		{Prepared: true, Name: "class2", Teacher: Teacher{Email: "teacher2@test.pearup.de"}, Pupils: []Pupil{{Username: "pupil1t2"}}},
+	}
+	p, err := NewPool(context.Background(), src, path, "localhost:27017/pearup", run, time.Hour)
+	if err != nil {
+		t.Fatalf("NewPool() error = %v", err)
+	}
+	return p
+}
+
+func TestPoolRelease(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "leases.json")
+	run1 := newTestPool(t, path, "run1")
+	run2 := newTestPool(t, path, "run2")
+	criteria := Criteria{Prepared: true, ClassSize: 1}
+
+	leased, err := run1.Lease(criteria, 2)
+	if err != nil {
+		t.Fatalf("Lease() error = %v", err)
+	}
+	if _, err := run2.Lease(criteria, 1); !errors.Is(err, ErrLeased) {
+		t.Fatalf("Lease() of leased classes error = %v, want %v", err, ErrLeased)
+	}
+
+	if err := run1.Release(leased[1]); err != nil {
+		t.Fatalf("Release() error = %v", err)
+	}
+	released, err := run2.Lease(criteria, 1)
+	if err != nil {
+		t.Fatalf("Lease() of a released class error = %v", err)
+	}
+	if released[0].Email != leased[1].Email {
+		t.Errorf("Lease() = %s, want the released %s", released[0].Email, leased[1].Email)
+	}
+
+	leases, err := Leases(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	runs := make(map[string]string)
+	for _, l := range leases {
+		runs[l.Teacher] = l.Run
+	}
+	want := map[string]string{leased[0].Email: "run1", leased[1].Email: "run2"}
+	if !reflect.DeepEqual(runs, want) {
+		t.Errorf("Leases() = %v, want %v", runs, want)
+	}
+}
+
+func TestPoolReleaseNil(t *testing.T) {
+	var p *Pool
+	if err := p.Release(Classroom{}); err != nil {
+		t.Errorf("Release() on a nil pool error = %v", err)
+	}
+}
@@ -0,0 +1,102 @@
+package accounts
+
+import (
+	"context"
+	"fmt"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// The collections PearUp stores what pupils and classes produce in.
+// Submissions reference their pupil by userId, class logs their class by classId.
+const (
+	exerciseSubmissionsCollection   = "exerciseSubmissions"
+	taskSeriesSubmissionsCollection = "taskSeriesSubmissions"
+	classLogsCollection             = "classLogs"
+)
+
+// Produced is what the test accounts produced during a run.
+type Produced struct {
+	// Exercises and TaskSeries count the submissions by username of the pupil.
+	Exercises  map[string]int
+	TaskSeries map[string]int
+	ClassLogs  int
+}
+
+// QueryProduced queries the database at dbUri for what the pupils and
+// classes of the test accounts have produced.
+func QueryProduced(ctx context.Context, dbUri string, classes []Classroom) (*Produced, error) {
+	client, err := mongo.Connect(ctx, options.Client().ApplyURI(dbUri))
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to %s: %w", Database(dbUri), err)
+	}
+	defer client.Disconnect(context.Background())
+
+	db := client.Database(renameNamespace(SeedDatabase))
+	var users []user
+	if err := findAll(ctx, db.Collection(usersCollection), newScope(classes).usersFilter(), &users); err != nil {
+		return nil, err
+	}
+	usernames := make(map[string]string)
+	// Empty, not nil, since $in needs an array
+	pupilIds, teacherIds := []string{}, []string{}
+	for _, u := range users {
+		if u.Username != "" {
+			usernames[u.Id] = u.Username
+			pupilIds = append(pupilIds, u.Id)
+		} else {
+			teacherIds = append(teacherIds, u.Id)
+		}
+	}
+
+	p := &Produced{}
+	if p.Exercises, err = countByUser(ctx, db.Collection(exerciseSubmissionsCollection), pupilIds, usernames); err != nil {
+		return nil, err
+	}
+	if p.TaskSeries, err = countByUser(ctx, db.Collection(taskSeriesSubmissionsCollection), pupilIds, usernames); err != nil {
+		return nil, err
+	}
+
+	var cls []class
+	if err := findAll(ctx, db.Collection(classesCollection), bson.M{"teacherId": bson.M{"$in": teacherIds}}, &cls); err != nil {
+		return nil, err
+	}
+	classIds := make([]string, len(cls))
+	for i, c := range cls {
+		classIds[i] = c.Id
+	}
+	n, err := db.Collection(classLogsCollection).CountDocuments(ctx, bson.M{"classId": bson.M{"$in": classIds}})
+	if err != nil {
+		return nil, fmt.Errorf("failed to count %s: %w", classLogsCollection, err)
+	}
+	p.ClassLogs = int(n)
+
+	return p, nil
+}
+
+// countByUser counts the documents of the users with ids by their username.
+func countByUser(ctx context.Context, coll *mongo.Collection, ids []string, usernames map[string]string) (map[string]int, error) {
+	cur, err := coll.Aggregate(ctx, mongo.Pipeline{
+		{{Key: "$match", Value: bson.M{"userId": bson.M{"$in": ids}}}},
+		{{Key: "$group", Value: bson.M{"_id": "$userId", "count": bson.M{"$sum": 1}}}},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to count %s: %w", coll.Name(), err)
+	}
+
+	var groups []struct {
+		Id    string `bson:"_id"`
+		Count int    `bson:"count"`
+	}
+	if err := cur.All(ctx, &groups); err != nil {
+		return nil, fmt.Errorf("failed to count %s: %w", coll.Name(), err)
+	}
+
+	counts := make(map[string]int, len(groups))
+	for _, g := range groups {
+		counts[usernames[g.Id]] = g.Count
+	}
+	return counts, nil
+}
@@ -0,0 +1,255 @@
+package accounts
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"strings"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// ownerFields are the fields through which documents of PearUp reference
+// the user who created them, classFields the ones referencing their class.
+var (
+	ownerFields = []string{"userId", "ownerId", "teacherId", "pupilId", "createdBy"}
+	classFields = []string{"classId"}
+)
+
+// ResetScoped resets only the state of the test accounts in the MongoDB at
+// dbUri, leaving all other data untouched. It deletes the test users, found
+// by the emails and usernames of the classes, every document referencing
+// them through one of the ownerFields, the classes of the test teachers and
+// every document referencing those through one of the classFields, and the
+// companies the test users reference. It then restores the documents of the
+// test accounts from the dump at path, which RestoreNative can read. It
+// returns the number of deleted and restored documents per namespace.
+func ResetScoped(ctx context.Context, dbUri, path string, classes []Classroom) (deleted, restored map[string]int, err error) {
+	client, err := mongo.Connect(ctx, options.Client().ApplyURI(dbUri))
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to connect to %s: %w", Database(dbUri), err)
+	}
+	defer client.Disconnect(context.Background())
+
+	sc := newScope(classes)
+	db := client.Database(renameNamespace(SeedDatabase))
+
+	var users []user
+	if err := findAll(ctx, db.Collection(usersCollection), sc.usersFilter(), &users); err != nil {
+		return nil, nil, err
+	}
+	ids := make([]string, len(users))
+	// Empty, not nil, since $in needs an array
+	companyIds := []string{}
+	for i, u := range users {
+		ids[i] = u.Id
+		if u.CompanyId != "" {
+			companyIds = append(companyIds, u.CompanyId)
+		}
+	}
+	var cls []class
+	if err := findAll(ctx, db.Collection(classesCollection), bson.M{"teacherId": bson.M{"$in": ids}}, &cls); err != nil {
+		return nil, nil, err
+	}
+	classIds := make([]string, len(cls))
+	for i, c := range cls {
+		classIds[i] = c.Id
+	}
+	if deleted, err = deleteScope(ctx, db, ids, classIds, companyIds); err != nil {
+		return nil, nil, err
+	}
+
+	restored, err = restoreScope(ctx, db, path, sc)
+	return deleted, restored, err
+}
+
+// scope identifies the users of the test accounts.
+type scope struct {
+	emails, usernames map[string]bool
+}
+
+func newScope(classes []Classroom) *scope {
+	sc := &scope{emails: make(map[string]bool), usernames: make(map[string]bool)}
+	for _, c := range classes {
+		sc.emails[c.Email] = true
+		for _, p := range c.Pupils {
+			sc.usernames[p.Username] = true
+		}
+	}
+	return sc
+}
+
+func (sc *scope) usersFilter() bson.M {
+	return bson.M{"$or": bson.A{
+		bson.M{"emails.address": bson.M{"$in": keys(sc.emails)}},
+		bson.M{"username": bson.M{"$in": keys(sc.usernames)}},
+	}}
+}
+
+// scopeFilter matches the documents of coll which belong to the users with
+// ids or the classes with classIds, and the companies with companyIds.
+func scopeFilter(coll string, ids, classIds, companyIds []string) bson.M {
+	or := bson.A{}
+	for _, f := range ownerFields {
+		or = append(or, bson.M{f: bson.M{"$in": ids}})
+	}
+	for _, f := range classFields {
+		or = append(or, bson.M{f: bson.M{"$in": classIds}})
+	}
+	switch coll {
+	case usersCollection:
+		or = append(or, bson.M{"_id": bson.M{"$in": ids}})
+	case classesCollection:
+		or = append(or, bson.M{"_id": bson.M{"$in": classIds}})
+	case companiesCollection:
+		or = append(or, bson.M{"_id": bson.M{"$in": companyIds}})
+	}
+	return bson.M{"$or": or}
+}
+
+func keys(m map[string]bool) []string {
+	ks := make([]string, 0, len(m))
+	for k := range m {
+		ks = append(ks, k)
+	}
+	return ks
+}
+
+func deleteScope(ctx context.Context, db *mongo.Database, ids, classIds, companyIds []string) (map[string]int, error) {
+	colls, err := db.ListCollectionNames(ctx, bson.M{"type": "collection"})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list collections of %s: %w", db.Name(), err)
+	}
+
+	deleted := make(map[string]int)
+	for _, coll := range colls {
+		if strings.HasPrefix(coll, "system.") {
+			continue
+		}
+		res, err := db.Collection(coll).DeleteMany(ctx, scopeFilter(coll, ids, classIds, companyIds))
+		if err != nil {
+			return nil, fmt.Errorf("failed to delete from %s.%s: %w", db.Name(), coll, err)
+		}
+		if res.DeletedCount > 0 {
+			deleted[db.Name()+"."+coll] = int(res.DeletedCount)
+		}
+	}
+	return deleted, nil
+}
+
+// restoreScope inserts the documents of the test accounts from the dump. It
+// reads the dump twice, first for the ids of the test users, their classes
+// and companies, since their documents may come after the ones referencing
+// them.
+func restoreScope(ctx context.Context, db *mongo.Database, path string, sc *scope) (map[string]int, error) {
+	ids := make(map[string]bool)
+	teachers := make(map[string]string)
+	companyIds := make(map[string]bool)
+	err := readSeedDump(path, func(coll string, doc bson.Raw) error {
+		if coll == classesCollection {
+			var c class
+			if err := bson.Unmarshal(doc, &c); err != nil {
+				return err
+			}
+			teachers[c.Id] = c.TeacherId
+			return nil
+		}
+		if coll != usersCollection {
+			return nil
+		}
+		var u user
+		if err := bson.Unmarshal(doc, &u); err != nil {
+			return err
+		}
+		if sc.usernames[u.Username] {
+			ids[u.Id] = true
+		}
+		for _, e := range u.Emails {
+			if sc.emails[e.Address] {
+				ids[u.Id] = true
+			}
+		}
+		if ids[u.Id] && u.CompanyId != "" {
+			companyIds[u.CompanyId] = true
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	classIds := make(map[string]bool)
+	for id, teacher := range teachers {
+		if ids[teacher] {
+			classIds[id] = true
+		}
+	}
+
+	restored := make(map[string]int)
+	err = readSeedDump(path, func(coll string, doc bson.Raw) error {
+		if !inScope(coll, doc, ids, classIds, companyIds) {
+			return nil
+		}
+		if _, err := db.Collection(coll).InsertOne(ctx, doc); err != nil {
+			return fmt.Errorf("failed to insert into %s.%s: %w", db.Name(), coll, err)
+		}
+		restored[db.Name()+"."+coll]++
+		return nil
+	})
+	return restored, err
+}
+
+// inScope reports whether doc of coll belongs to the users with ids or the
+// classes with classIds, or is one of the companies with companyIds.
+func inScope(coll string, doc bson.Raw, ids, classIds, companyIds map[string]bool) bool {
+	str := func(key string) string {
+		s, _ := doc.Lookup(key).StringValueOK()
+		return s
+	}
+	if coll == usersCollection && ids[str("_id")] {
+		return true
+	}
+	if coll == classesCollection && classIds[str("_id")] {
+		return true
+	}
+	if coll == companiesCollection && companyIds[str("_id")] {
+		return true
+	}
+	for _, f := range ownerFields {
+		if ids[str(f)] {
+			return true
+		}
+	}
+	for _, f := range classFields {
+		if classIds[str(f)] {
+			return true
+		}
+	}
+	return false
+}
+
+// readSeedDump calls fn with every document of the SeedDatabase in the dump at path.
+func readSeedDump(path string, fn func(coll string, doc bson.Raw) error) error {
+	src, err := openDump(path)
+	if err != nil {
+		return fmt.Errorf("failed to open dump %s: %w", path, err)
+	}
+	defer src.Close()
+
+	for {
+		db, coll, doc, err := src.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("failed to read dump %s: %w", path, err)
+		}
+		if db != SeedDatabase || strings.HasPrefix(coll, "system.") {
+			continue
+		}
+		if err := fn(coll, doc); err != nil {
+			return err
+		}
+	}
+}
@@ -0,0 +1,41 @@
+package accounts
+
+import (
+	"testing"
+
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+func TestInScope(t *testing.T) {
+	ids := map[string]bool{"pupil": true}
+	classIds := map[string]bool{"class": true}
+	companyIds := map[string]bool{"company": true}
+
+	tests := []struct {
+		name string
+		coll string
+		doc  bson.M
+		want bool
+	}{
+		{"test user", usersCollection, bson.M{"_id": "pupil"}, true},
+		{"other user", usersCollection, bson.M{"_id": "other"}, false},
+		{"test class", classesCollection, bson.M{"_id": "class", "teacherId": "other"}, true},
+		{"test company", companiesCollection, bson.M{"_id": "company", "name": "company1t1"}, true},
+		{"company named like a test company", companiesCollection, bson.M{"_id": "other", "name": "company1t1"}, false},
+		{"owned document", "submissions", bson.M{"_id": "s1", "pupilId": "pupil"}, true},
+		{"document of a test class", "submissions", bson.M{"_id": "s2", "classId": "class"}, true},
+		{"other document", "submissions", bson.M{"_id": "s3", "pupilId": "other", "classId": "other"}, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			doc, err := bson.Marshal(tt.doc)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := inScope(tt.coll, doc, ids, classIds, companyIds); got != tt.want {
+				t.Errorf("inScope(%s, %v) = %v, want %v", tt.coll, tt.doc, got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,549 @@
+package accounts
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// RestoredCollection is the number of documents restored into a namespace.
+type RestoredCollection struct {
+	Namespace string
+	Documents int
+}
+
+const (
+	archiveMagic      = 0x8199e26d
+	archiveTerminator = 0xffffffff
+	insertBatchDocs   = 1000
+	insertBatchBytes  = 8 << 20
+)
+
+// RestoreNative restores the dump at path into the MongoDB at dbUri using the
+// Go driver instead of mongorestore, renaming meteor.* to pearup.* the same
+// way. The dump is either a mongodump archive, optionally gzipped, or a
+// directory of fixtures as written by mongodump --out: <db>/<collection>.bson
+// or, one extended JSON document per line, <db>/<collection>.json, each with
+// an optional <db>/<collection>.metadata.json. Every restored collection is
+// dropped first, progress is called after each batch of inserted documents.
+// The restored collections are verified to hold all documents of the dump.
+func RestoreNative(ctx context.Context, dbUri, path string, progress func(RestoredCollection)) ([]RestoredCollection, error) {
+	src, err := openDump(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open dump %s: %w", path, err)
+	}
+	defer src.Close()
+
+	client, err := mongo.Connect(ctx, options.Client().ApplyURI(dbUri))
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to %s: %w", Database(dbUri), err)
+	}
+	defer client.Disconnect(context.Background())
+
+	r := &restorer{client: client, progress: progress, counts: make(map[string]int), batches: make(map[string]*batch)}
+	for _, c := range src.Collections() {
+		if err := r.create(ctx, c); err != nil {
+			return nil, err
+		}
+	}
+
+	for {
+		db, coll, doc, err := src.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to read dump %s: %w", path, err)
+		}
+		if err := r.add(ctx, db, coll, doc); err != nil {
+			return nil, err
+		}
+	}
+	if err := r.flushAll(ctx); err != nil {
+		return nil, err
+	}
+
+	for _, c := range src.Collections() {
+		if err := r.createIndexes(ctx, c); err != nil {
+			return nil, err
+		}
+	}
+
+	restored := r.restored()
+	return restored, r.verify(ctx, restored)
+}
+
+// renameNamespace maps a database of the dump to the one to restore into.
+func renameNamespace(db string) string {
+	from, to := strings.TrimSuffix(nsFrom, ".*"), strings.TrimSuffix(nsTo, ".*")
+	if db == from {
+		return to
+	}
+	return db
+}
+
+// collectionMeta describes a collection of a dump.
+type collectionMeta struct {
+	DB         string
+	Collection string
+	Options    bson.D
+	Indexes    []bson.D
+}
+
+// dumpSource reads the collections and documents of a dump.
+type dumpSource interface {
+	Collections() []collectionMeta
+	// Next returns the next document of the dump, or io.EOF at its end.
+	Next() (db, coll string, doc bson.Raw, err error)
+	Close() error
+}
+
+func openDump(path string) (dumpSource, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+	if info.IsDir() {
+		return openFixtures(path)
+	}
+	return openArchive(path)
+}
+
+type restorer struct {
+	client   *mongo.Client
+	progress func(RestoredCollection)
+	// counts are the documents inserted per target namespace.
+	counts  map[string]int
+	batches map[string]*batch
+}
+
+type batch struct {
+	db, coll string
+	docs     []interface{}
+	bytes    int
+}
+
+func (r *restorer) create(ctx context.Context, c collectionMeta) error {
+	if strings.HasPrefix(c.Collection, "system.") {
+		return nil
+	}
+
+	db := r.client.Database(renameNamespace(c.DB))
+	ns := db.Name() + "." + c.Collection
+	if err := db.Collection(c.Collection).Drop(ctx); err != nil {
+		return fmt.Errorf("failed to drop %s: %w", ns, err)
+	}
+	cmd := append(bson.D{{Key: "create", Value: c.Collection}}, c.Options...)
+	if err := db.RunCommand(ctx, cmd).Err(); err != nil {
+		return fmt.Errorf("failed to create %s: %w", ns, err)
+	}
+	r.counts[ns] = 0
+	return nil
+}
+
+func (r *restorer) add(ctx context.Context, db, coll string, doc bson.Raw) error {
+	if strings.HasPrefix(coll, "system.") {
+		return nil
+	}
+
+	ns := renameNamespace(db) + "." + coll
+	b, ok := r.batches[ns]
+	if !ok {
+		b = &batch{db: renameNamespace(db), coll: coll}
+		r.batches[ns] = b
+	}
+	b.docs = append(b.docs, doc)
+	b.bytes += len(doc)
+	if len(b.docs) >= insertBatchDocs || b.bytes >= insertBatchBytes {
+		return r.flush(ctx, ns, b)
+	}
+	return nil
+}
+
+func (r *restorer) flush(ctx context.Context, ns string, b *batch) error {
+	if len(b.docs) == 0 {
+		return nil
+	}
+
+	opts := options.InsertMany().SetBypassDocumentValidation(true)
+	if _, err := r.client.Database(b.db).Collection(b.coll).InsertMany(ctx, b.docs, opts); err != nil {
+		return fmt.Errorf("failed to insert into %s: %w", ns, err)
+	}
+	r.counts[ns] += len(b.docs)
+	b.docs, b.bytes = nil, 0
+
+	if r.progress != nil {
+		r.progress(RestoredCollection{Namespace: ns, Documents: r.counts[ns]})
+	}
+	return nil
+}
+
+func (r *restorer) flushAll(ctx context.Context) error {
+	for ns, b := range r.batches {
+		if err := r.flush(ctx, ns, b); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (r *restorer) createIndexes(ctx context.Context, c collectionMeta) error {
+	var indexes bson.A
+	for _, idx := range c.Indexes {
+		var spec bson.D
+		isId := false
+		for _, e := range idx {
+			switch {
+			case e.Key == "name" && e.Value == "_id_":
+				isId = true
+			case e.Key == "ns":
+				// Newer servers reject the namespace older dumps record
+				continue
+			}
+			spec = append(spec, e)
+		}
+		if !isId {
+			indexes = append(indexes, spec)
+		}
+	}
+	if len(indexes) == 0 || strings.HasPrefix(c.Collection, "system.") {
+		return nil
+	}
+
+	db := r.client.Database(renameNamespace(c.DB))
+	cmd := bson.D{{Key: "createIndexes", Value: c.Collection}, {Key: "indexes", Value: indexes}}
+	if err := db.RunCommand(ctx, cmd).Err(); err != nil {
+		return fmt.Errorf("failed to create indexes of %s.%s: %w", db.Name(), c.Collection, err)
+	}
+	return nil
+}
+
+func (r *restorer) restored() []RestoredCollection {
+	restored := make([]RestoredCollection, 0, len(r.counts))
+	for ns, n := range r.counts {
+		restored = append(restored, RestoredCollection{Namespace: ns, Documents: n})
+	}
+	sort.Slice(restored, func(i, j int) bool {
+		return restored[i].Namespace < restored[j].Namespace
+	})
+	return restored
+}
+
+// verify checks that the restored collections hold as many documents as the dump.
+func (r *restorer) verify(ctx context.Context, restored []RestoredCollection) error {
+	var mismatches []string
+	for _, c := range restored {
+		parts := strings.SplitN(c.Namespace, ".", 2)
+		n, err := r.client.Database(parts[0]).Collection(parts[1]).CountDocuments(ctx, bson.D{})
+		if err != nil {
+			return fmt.Errorf("failed to count %s: %w", c.Namespace, err)
+		}
+		if int(n) != c.Documents {
+			mismatches = append(mismatches, fmt.Sprintf("%s has %d of %d documents", c.Namespace, n, c.Documents))
+		}
+	}
+
+	if len(mismatches) > 0 {
+		return fmt.Errorf("restored database differs from the dump: %s", strings.Join(mismatches, ", "))
+	}
+	return nil
+}
+
+// archive reads a mongodump archive: a magic number and header, a prelude
+// of collection metadata, and blocks of documents of a single namespace.
+// Blocks of different namespaces may be interleaved.
+type archive struct {
+	f           *os.File
+	r           *bufio.Reader
+	collections []collectionMeta
+	// db and coll are the namespace of the current block, if any.
+	db, coll string
+	inBlock  bool
+}
+
+func openArchive(path string) (*archive, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	a := &archive{f: f, r: bufio.NewReader(f)}
+	if err := a.readPrelude(); err != nil {
+		f.Close()
+		return nil, err
+	}
+	return a, nil
+}
+
+func (a *archive) readPrelude() error {
+	if magic, _ := a.r.Peek(2); bytes.Equal(magic, []byte{0x1f, 0x8b}) {
+		gz, err := gzip.NewReader(a.r)
+		if err != nil {
+			return err
+		}
+		a.r = bufio.NewReader(gz)
+	}
+
+	var magic uint32
+	if err := binary.Read(a.r, binary.LittleEndian, &magic); err != nil {
+		return err
+	}
+	if magic != archiveMagic {
+		return errors.New("not a mongodump archive")
+	}
+	if _, _, err := a.readDoc(); err != nil {
+		return fmt.Errorf("malformed archive header: %w", err)
+	}
+
+	for {
+		doc, end, err := a.readDoc()
+		if err != nil {
+			return fmt.Errorf("malformed archive prelude: %w", err)
+		}
+		if end {
+			return nil
+		}
+
+		var m struct {
+			DB         string `bson:"db"`
+			Collection string `bson:"collection"`
+			Metadata   string `bson:"metadata"`
+		}
+		if err := bson.Unmarshal(doc, &m); err != nil {
+			return fmt.Errorf("malformed collection metadata: %w", err)
+		}
+		c := collectionMeta{DB: m.DB, Collection: m.Collection}
+		if err := parseMetadata([]byte(m.Metadata), &c); err != nil {
+			return fmt.Errorf("malformed metadata of %s.%s: %w", m.DB, m.Collection, err)
+		}
+		a.collections = append(a.collections, c)
+	}
+}
+
+func (a *archive) Collections() []collectionMeta {
+	return a.collections
+}
+
+func (a *archive) Next() (string, string, bson.Raw, error) {
+	for {
+		if !a.inBlock {
+			header, end, err := a.readDoc()
+			if err != nil {
+				return "", "", nil, err
+			}
+			if end {
+				return "", "", nil, errors.New("unexpected terminator")
+			}
+			var h struct {
+				DB         string `bson:"db"`
+				Collection string `bson:"collection"`
+				EOF        bool   `bson:"EOF"`
+			}
+			if err := bson.Unmarshal(header, &h); err != nil {
+				return "", "", nil, fmt.Errorf("malformed block header: %w", err)
+			}
+			a.db, a.coll, a.inBlock = h.DB, h.Collection, true
+		}
+
+		doc, end, err := a.readDoc()
+		if err == io.EOF {
+			return "", "", nil, io.ErrUnexpectedEOF
+		}
+		if err != nil {
+			return "", "", nil, err
+		}
+		if end {
+			a.inBlock = false
+			continue
+		}
+		return a.db, a.coll, doc, nil
+	}
+}
+
+// readDoc reads the next BSON document, or reports a terminator. It returns
+// io.EOF only at the very end of the archive.
+func (a *archive) readDoc() (bson.Raw, bool, error) {
+	var size [4]byte
+	if _, err := io.ReadFull(a.r, size[:]); err != nil {
+		return nil, false, err
+	}
+	n := binary.LittleEndian.Uint32(size[:])
+	if n == archiveTerminator {
+		return nil, true, nil
+	}
+	if n < 5 {
+		return nil, false, fmt.Errorf("invalid document size %d", n)
+	}
+
+	doc := make([]byte, n)
+	copy(doc, size[:])
+	if _, err := io.ReadFull(a.r, doc[4:]); err != nil {
+		return nil, false, io.ErrUnexpectedEOF
+	}
+	if err := bson.Raw(doc).Validate(); err != nil {
+		return nil, false, err
+	}
+	return doc, false, nil
+}
+
+func (a *archive) Close() error {
+	return a.f.Close()
+}
+
+// parseMetadata parses the extended JSON metadata mongodump records of a collection.
+func parseMetadata(b []byte, c *collectionMeta) error {
+	if len(bytes.TrimSpace(b)) == 0 {
+		return nil
+	}
+
+	var m struct {
+		Options bson.D   `bson:"options"`
+		Indexes []bson.D `bson:"indexes"`
+	}
+	if err := bson.UnmarshalExtJSON(b, false, &m); err != nil {
+		return err
+	}
+	c.Options, c.Indexes = m.Options, m.Indexes
+	return nil
+}
+
+// fixtures reads a directory of collection files, one after another.
+type fixtures struct {
+	collections []collectionMeta
+	files       []string
+	current     int
+	f           *os.File
+	bson        *bufio.Reader
+	json        *bufio.Scanner
+}
+
+func openFixtures(dir string) (*fixtures, error) {
+	var files []string
+	for _, ext := range []string{"*.bson", "*.json"} {
+		matches, err := filepath.Glob(filepath.Join(dir, "*", ext))
+		if err != nil {
+			return nil, err
+		}
+		for _, m := range matches {
+			if !strings.HasSuffix(m, ".metadata.json") {
+				files = append(files, m)
+			}
+		}
+	}
+	sort.Strings(files)
+	if len(files) == 0 {
+		return nil, fmt.Errorf("no <db>/<collection>.bson or .json files in %s", dir)
+	}
+
+	fx := &fixtures{files: files, current: -1}
+	for _, file := range files {
+		db, coll := fixtureNamespace(file)
+		c := collectionMeta{DB: db, Collection: coll}
+		b, err := ioutil.ReadFile(filepath.Join(filepath.Dir(file), coll+".metadata.json"))
+		if err != nil && !errors.Is(err, os.ErrNotExist) {
+			return nil, err
+		}
+		if err := parseMetadata(b, &c); err != nil {
+			return nil, fmt.Errorf("malformed metadata of %s.%s: %w", db, coll, err)
+		}
+		fx.collections = append(fx.collections, c)
+	}
+	return fx, nil
+}
+
+func fixtureNamespace(file string) (string, string) {
+	coll := strings.TrimSuffix(filepath.Base(file), filepath.Ext(file))
+	return filepath.Base(filepath.Dir(file)), coll
+}
+
+func (fx *fixtures) Collections() []collectionMeta {
+	return fx.collections
+}
+
+func (fx *fixtures) Next() (string, string, bson.Raw, error) {
+	for {
+		if fx.f == nil {
+			if err := fx.openNext(); err != nil {
+				return "", "", nil, err
+			}
+		}
+		db, coll := fixtureNamespace(fx.files[fx.current])
+
+		doc, err := fx.readDoc()
+		if err == io.EOF {
+			fx.f.Close()
+			fx.f = nil
+			continue
+		}
+		if err != nil {
+			return "", "", nil, fmt.Errorf("%s: %w", fx.files[fx.current], err)
+		}
+		return db, coll, doc, nil
+	}
+}
+
+func (fx *fixtures) openNext() error {
+	fx.current++
+	if fx.current >= len(fx.files) {
+		return io.EOF
+	}
+
+	f, err := os.Open(fx.files[fx.current])
+	if err != nil {
+		return err
+	}
+	fx.f, fx.bson, fx.json = f, nil, nil
+	if filepath.Ext(f.Name()) == ".json" {
+		fx.json = bufio.NewScanner(f)
+		fx.json.Buffer(nil, 16<<20)
+	} else {
+		fx.bson = bufio.NewReader(f)
+	}
+	return nil
+}
+
+func (fx *fixtures) readDoc() (bson.Raw, error) {
+	if fx.json != nil {
+		for fx.json.Scan() {
+			line := bytes.TrimSpace(fx.json.Bytes())
+			if len(line) == 0 {
+				continue
+			}
+			var doc bson.D
+			if err := bson.UnmarshalExtJSON(line, false, &doc); err != nil {
+				return nil, err
+			}
+			return bson.Marshal(doc)
+		}
+		if err := fx.json.Err(); err != nil {
+			return nil, err
+		}
+		return nil, io.EOF
+	}
+
+	doc, err := bson.NewFromIOReader(fx.bson)
+	if err == io.EOF {
+		return nil, io.EOF
+	}
+	return doc, err
+}
+
+func (fx *fixtures) Close() error {
+	if fx.f != nil {
+		return fx.f.Close()
+	}
+	return nil
+}
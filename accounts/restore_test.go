@@ -0,0 +1,343 @@
+package accounts
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/binary"
+	"errors"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// archiveBuilder writes mongodump archives for tests.
+type archiveBuilder struct {
+	buf bytes.Buffer
+}
+
+func newArchive(t *testing.T, collections ...collectionMeta) *archiveBuilder {
+	a := &archiveBuilder{}
+	binary.Write(&a.buf, binary.LittleEndian, uint32(archiveMagic))
+	a.doc(t, bson.M{"version": "0.1", "server": "4.4.4", "tool": "100.3.1"})
+	for _, c := range collections {
+		a.doc(t, bson.M{"db": c.DB, "collection": c.Collection, "metadata": `{"indexes":[{"v":2,"key":{"_id":1},"name":"_id_"},{"v":2,"key":{"username":1},"name":"username_1"}]}`})
+	}
+	a.terminator()
+	return a
+}
+
+// block writes a block of the documents of a namespace.
+func (a *archiveBuilder) block(t *testing.T, db, coll string, ids ...string) *archiveBuilder {
+	a.doc(t, bson.M{"db": db, "collection": coll})
+	for _, id := range ids {
+		a.doc(t, bson.M{"_id": id})
+	}
+	a.terminator()
+	return a
+}
+
+// eof writes the empty block which ends a namespace.
+func (a *archiveBuilder) eof(t *testing.T, db, coll string) *archiveBuilder {
+	a.doc(t, bson.M{"db": db, "collection": coll, "EOF": true, "CRC": int64(0)})
+	a.terminator()
+	return a
+}
+
+func (a *archiveBuilder) doc(t *testing.T, doc interface{}) {
+	b, err := bson.Marshal(doc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	a.buf.Write(b)
+}
+
+func (a *archiveBuilder) terminator() {
+	binary.Write(&a.buf, binary.LittleEndian, uint32(archiveTerminator))
+}
+
+func (a *archiveBuilder) gzipped(t *testing.T) []byte {
+	var buf bytes.Buffer
+	gz := gzip.NewWriter(&buf)
+	if _, err := gz.Write(a.buf.Bytes()); err != nil {
+		t.Fatal(err)
+	}
+	if err := gz.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+// readAll returns the namespaces and ids of all documents of the dump.
+func readAll(src dumpSource) ([]string, error) {
+	var docs []string
+	for {
+		db, coll, doc, err := src.Next()
+		if err == io.EOF {
+			return docs, nil
+		}
+		if err != nil {
+			return docs, err
+		}
+		id, _ := doc.Lookup("_id").StringValueOK()
+		docs = append(docs, db+"."+coll+"/"+id)
+	}
+}
+
+func writeFile(t *testing.T, path string, data []byte) {
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(path, data, 0o644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestArchive(t *testing.T) {
+	users := collectionMeta{DB: "meteor", Collection: "users"}
+	classes := collectionMeta{DB: "meteor", Collection: "classes"}
+
+	tests := []struct {
+		name    string
+		archive func(t *testing.T) []byte
+		want    []string
+		wantErr error
+	}{
+		{
+			name: "single block",
+			archive: func(t *testing.T) []byte {
+				a := newArchive(t, users).block(t, "meteor", "users", "u1", "u2").eof(t, "meteor", "users")
+				return a.buf.Bytes()
+			},
+			want: []string{"meteor.users/u1", "meteor.users/u2"},
+		},
+		{
+			name: "interleaved blocks",
+			archive: func(t *testing.T) []byte {
+				a := newArchive(t, users, classes).
+					block(t, "meteor", "users", "u1").
+					block(t, "meteor", "classes", "c1").
+					block(t, "meteor", "users", "u2").
+					eof(t, "meteor", "classes").
+					block(t, "meteor", "users", "u3").
+					eof(t, "meteor", "users")
+				return a.buf.Bytes()
+			},
+			want: []string{"meteor.users/u1", "meteor.classes/c1", "meteor.users/u2", "meteor.users/u3"},
+		},
+		{
+			name: "empty collection",
+			archive: func(t *testing.T) []byte {
+				return newArchive(t, users).eof(t, "meteor", "users").buf.Bytes()
+			},
+		},
+		{
+			name: "gzip",
+			archive: func(t *testing.T) []byte {
+				a := newArchive(t, users, classes).
+					block(t, "meteor", "classes", "c1").
+					block(t, "meteor", "users", "u1").
+					eof(t, "meteor", "users").
+					eof(t, "meteor", "classes")
+				return a.gzipped(t)
+			},
+			want: []string{"meteor.classes/c1", "meteor.users/u1"},
+		},
+		{
+			name: "block without terminator",
+			archive: func(t *testing.T) []byte {
+				a := newArchive(t, users)
+				a.doc(t, bson.M{"db": "meteor", "collection": "users"})
+				a.doc(t, bson.M{"_id": "u1"})
+				return a.buf.Bytes()
+			},
+			want:    []string{"meteor.users/u1"},
+			wantErr: io.ErrUnexpectedEOF,
+		},
+		{
+			name: "truncated document",
+			archive: func(t *testing.T) []byte {
+				b := newArchive(t, users).block(t, "meteor", "users", "u1").buf.Bytes()
+				// Cuts into the terminator and the document before it
+				return b[:len(b)-6]
+			},
+			wantErr: io.ErrUnexpectedEOF,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "dump")
+			writeFile(t, path, tt.archive(t))
+
+			a, err := openArchive(path)
+			if err != nil {
+				t.Fatalf("openArchive() error = %v", err)
+			}
+			defer a.Close()
+
+			got, err := readAll(a)
+			if !errors.Is(err, tt.wantErr) {
+				t.Errorf("Next() error = %v, want %v", err, tt.wantErr)
+			}
+			if strings.Join(got, ",") != strings.Join(tt.want, ",") {
+				t.Errorf("Next() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestReadPrelude(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "dump")
+	users := collectionMeta{DB: "meteor", Collection: "users"}
+	writeFile(t, path, newArchive(t, users, collectionMeta{DB: "meteor", Collection: "classes"}).gzipped(t))
+
+	a, err := openArchive(path)
+	if err != nil {
+		t.Fatalf("openArchive() error = %v", err)
+	}
+	defer a.Close()
+
+	colls := a.Collections()
+	if len(colls) != 2 || colls[0].Collection != "users" || colls[1].Collection != "classes" {
+		t.Fatalf("Collections() = %v, want users and classes", colls)
+	}
+	if len(colls[0].Indexes) != 2 {
+		t.Fatalf("Indexes = %v, want _id_ and username_1", colls[0].Indexes)
+	}
+	if name, _ := colls[0].Indexes[1].Map()["name"].(string); name != "username_1" {
+		t.Errorf("Indexes[1] = %v, want username_1", colls[0].Indexes[1])
+	}
+}
+
+func TestReadPreludeErrors(t *testing.T) {
+	tests := []struct {
+		name    string
+		archive []byte
+		wantErr string
+	}{
+		{name: "empty", archive: nil, wantErr: "EOF"},
+		{name: "wrong magic", archive: []byte{1, 2, 3, 4, 5, 0, 0, 0, 0}, wantErr: "not a mongodump archive"},
+		{name: "missing prelude terminator", archive: func() []byte {
+			a := newArchive(t)
+			return a.buf.Bytes()[:a.buf.Len()-4]
+		}(), wantErr: "malformed archive prelude"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "dump")
+			writeFile(t, path, tt.archive)
+
+			_, err := openArchive(path)
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("openArchive() error = %v, want %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestFixtures(t *testing.T) {
+	bsonDocs := func(ids ...string) []byte {
+		var buf bytes.Buffer
+		for _, id := range ids {
+			b, err := bson.Marshal(bson.M{"_id": id})
+			if err != nil {
+				t.Fatal(err)
+			}
+			buf.Write(b)
+		}
+		return buf.Bytes()
+	}
+
+	tests := []struct {
+		name    string
+		files   map[string]string
+		want    []string
+		wantErr string
+	}{
+		{
+			name: "bson and json",
+			files: map[string]string{
+				"meteor/users.json":            `{"_id": "u1", "createdAt": {"$date": "2021-03-01T00:00:00Z"}}` + "\n\n" + `{"_id": "u2"}` + "\n",
+				"meteor/classes.bson":          string(bsonDocs("c1", "c2")),
+				"meteor/classes.metadata.json": `{"options": {}, "indexes": [{"v": 2, "key": {"_id": 1}, "name": "_id_"}]}`,
+				"other/logs.json":              `{"_id": "l1"}`,
+			},
+			want: []string{"meteor.classes/c1", "meteor.classes/c2", "meteor.users/u1", "meteor.users/u2", "other.logs/l1"},
+		},
+		{
+			name:  "empty file",
+			files: map[string]string{"meteor/users.bson": "", "meteor/classes.json": `{"_id": "c1"}`},
+			want:  []string{"meteor.classes/c1"},
+		},
+		{
+			name:    "malformed json",
+			files:   map[string]string{"meteor/users.json": `{"_id": "u1"}` + "\n" + `{"_id": `},
+			want:    []string{"meteor.users/u1"},
+			wantErr: "users.json",
+		},
+		{
+			name:    "truncated bson",
+			files:   map[string]string{"meteor/users.bson": string(bsonDocs("u1", "u2")[:30])},
+			want:    []string{"meteor.users/u1"},
+			wantErr: "users.bson",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			for name, content := range tt.files {
+				writeFile(t, filepath.Join(dir, name), []byte(content))
+			}
+
+			fx, err := openFixtures(dir)
+			if err != nil {
+				t.Fatalf("openFixtures() error = %v", err)
+			}
+			defer fx.Close()
+
+			got, err := readAll(fx)
+			if tt.wantErr == "" && err != nil || tt.wantErr != "" && (err == nil || !strings.Contains(err.Error(), tt.wantErr)) {
+				t.Errorf("Next() error = %v, want %q", err, tt.wantErr)
+			}
+			if strings.Join(got, ",") != strings.Join(tt.want, ",") {
+				t.Errorf("Next() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestFixturesMetadata(t *testing.T) {
+	dir := t.TempDir()
+	writeFile(t, filepath.Join(dir, "meteor/users.json"), []byte(`{"_id": "u1"}`))
+	writeFile(t, filepath.Join(dir, "meteor/users.metadata.json"), []byte(`{"options": {"validationLevel": "off"}, "indexes": [{"v": 2, "key": {"username": 1}, "name": "username_1"}]}`))
+
+	fx, err := openFixtures(dir)
+	if err != nil {
+		t.Fatalf("openFixtures() error = %v", err)
+	}
+	defer fx.Close()
+
+	colls := fx.Collections()
+	if len(colls) != 1 || colls[0].DB != "meteor" || colls[0].Collection != "users" {
+		t.Fatalf("Collections() = %v, want meteor.users", colls)
+	}
+	if len(colls[0].Options) != 1 || colls[0].Options[0].Key != "validationLevel" {
+		t.Errorf("Options = %v, want validationLevel", colls[0].Options)
+	}
+	if len(colls[0].Indexes) != 1 {
+		t.Errorf("Indexes = %v, want username_1", colls[0].Indexes)
+	}
+}
+
+func TestOpenFixturesEmpty(t *testing.T) {
+	if _, err := openFixtures(t.TempDir()); err == nil {
+		t.Error("openFixtures() of an empty directory should fail")
+	}
+}
@@ -0,0 +1,222 @@
+package accounts
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"os/exec"
+	"time"
+
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"golang.org/x/crypto/bcrypt"
+)
+
+const (
+	// SeedDatabase is the database the accounts are seeded into, the one of a
+	// local Meteor server. Restoring renames it to the database of PearUp.
+	SeedDatabase = "meteor"
+
+	usersCollection     = "users"
+	classesCollection   = "classes"
+	companiesCollection = "companies"
+
+	// meteorIdChars are the characters of the ids Meteor generates.
+	meteorIdChars  = "23456789ABCDEFGHJKLMNPQRSTWXYZabcdefghijkmnopqrstuvwxyz"
+	meteorIdLength = 17
+	classCodeChars = "ABCDEFGHJKLMNPQRSTUVWXYZ23456789"
+	classCodeLen   = 6
+	// bcryptCost is the cost Meteor's accounts-password uses.
+	bcryptCost = 10
+)
+
+type user struct {
+	Id        string    `bson:"_id"`
+	CreatedAt time.Time `bson:"createdAt"`
+	Username  string    `bson:"username,omitempty"`
+	Emails    []email   `bson:"emails,omitempty"`
+	Services  services  `bson:"services"`
+	Roles     []string  `bson:"roles"`
+	ClassId   string    `bson:"classId,omitempty"`
+	CompanyId string    `bson:"companyId,omitempty"`
+}
+
+type email struct {
+	Address  string `bson:"address"`
+	Verified bool   `bson:"verified"`
+}
+
+type services struct {
+	Password struct {
+		Bcrypt string `bson:"bcrypt"`
+	} `bson:"password"`
+}
+
+type class struct {
+	Id        string    `bson:"_id"`
+	CreatedAt time.Time `bson:"createdAt"`
+	Name      string    `bson:"name"`
+	Code      string    `bson:"code"`
+	TeacherId string    `bson:"teacherId"`
+	PupilIds  []string  `bson:"pupilIds"`
+}
+
+type company struct {
+	Id        string    `bson:"_id"`
+	CreatedAt time.Time `bson:"createdAt"`
+	Name      string    `bson:"name"`
+	ClassId   string    `bson:"classId"`
+	OwnerId   string    `bson:"ownerId"`
+}
+
+// Seed writes the prepared classes directly into the SeedDatabase of the
+// MongoDB at dbUri, the way PearUp stores them once teachers and pupils have
+// signed up: the users with their Meteor password hashes, the classes and
+// the pupils' companies. Unprepared classes sign up during a run, so nothing
+// is written for them. The seeded collections are replaced.
+func Seed(ctx context.Context, dbUri string, classes []Classroom) error {
+	client, err := mongo.Connect(ctx, options.Client().ApplyURI(dbUri))
+	if err != nil {
+		return fmt.Errorf("failed to connect to %s: %w", Database(dbUri), err)
+	}
+	defer client.Disconnect(context.Background())
+
+	docs, err := seedDocuments(classes)
+	if err != nil {
+		return err
+	}
+
+	db := client.Database(SeedDatabase)
+	for _, coll := range []string{usersCollection, classesCollection, companiesCollection} {
+		if err := db.Collection(coll).Drop(ctx); err != nil {
+			return fmt.Errorf("failed to drop %s: %w", coll, err)
+		}
+		if len(docs[coll]) == 0 {
+			continue
+		}
+		if _, err := db.Collection(coll).InsertMany(ctx, docs[coll]); err != nil {
+			return fmt.Errorf("failed to insert %s: %w", coll, err)
+		}
+	}
+
+	return nil
+}
+
+// seedDocuments returns the documents of the prepared classes by collection.
+func seedDocuments(classes []Classroom) (map[string][]interface{}, error) {
+	docs := make(map[string][]interface{})
+	hashes := newPasswordHasher()
+	now := time.Now()
+
+	for _, c := range classes {
+		if !c.Prepared {
+			continue
+		}
+
+		teacher := user{CreatedAt: now, Emails: []email{{Address: c.Email, Verified: true}}, Roles: []string{"teacher"}}
+		cl := class{CreatedAt: now, Name: c.Name}
+		var err error
+		if teacher.Id, err = meteorId(); err != nil {
+			return nil, err
+		}
+		if cl.Id, err = meteorId(); err != nil {
+			return nil, err
+		}
+		if cl.Code, err = randomString(classCodeChars, classCodeLen); err != nil {
+			return nil, err
+		}
+		if teacher.Services.Password.Bcrypt, err = hashes.hash(c.Teacher.Password); err != nil {
+			return nil, err
+		}
+		cl.TeacherId = teacher.Id
+		teacher.ClassId = cl.Id
+		docs[usersCollection] = append(docs[usersCollection], teacher)
+
+		for _, p := range c.Pupils {
+			pupil := user{CreatedAt: now, Username: p.Username, Roles: []string{"pupil"}, ClassId: cl.Id}
+			comp := company{CreatedAt: now, Name: p.Company, ClassId: cl.Id}
+			if pupil.Id, err = meteorId(); err != nil {
+				return nil, err
+			}
+			if comp.Id, err = meteorId(); err != nil {
+				return nil, err
+			}
+			if pupil.Services.Password.Bcrypt, err = hashes.hash(p.Password); err != nil {
+				return nil, err
+			}
+			comp.OwnerId = pupil.Id
+			pupil.CompanyId = comp.Id
+			cl.PupilIds = append(cl.PupilIds, pupil.Id)
+
+			docs[usersCollection] = append(docs[usersCollection], pupil)
+			docs[companiesCollection] = append(docs[companiesCollection], comp)
+		}
+		docs[classesCollection] = append(docs[classesCollection], cl)
+	}
+
+	return docs, nil
+}
+
+// passwordHasher hashes passwords the way Meteor does, bcrypt of the hex
+// encoded SHA-256 digest. Hashes are reused for identical passwords, since
+// bcrypt is deliberately slow and most accounts share a password.
+type passwordHasher map[string]string
+
+func newPasswordHasher() passwordHasher {
+	return make(passwordHasher)
+}
+
+func (h passwordHasher) hash(password string) (string, error) {
+	if hash, ok := h[password]; ok {
+		return hash, nil
+	}
+
+	hash, err := bcrypt.GenerateFromPassword(meteorDigest(password), bcryptCost)
+	if err != nil {
+		return "", err
+	}
+	h[password] = string(hash)
+	return string(hash), nil
+}
+
+// meteorDigest returns the digest Meteor clients send instead of the password.
+func meteorDigest(password string) []byte {
+	digest := sha256.Sum256([]byte(password))
+	return []byte(hex.EncodeToString(digest[:]))
+}
+
+func meteorId() (string, error) {
+	return randomString(meteorIdChars, meteorIdLength)
+}
+
+func randomString(chars string, n int) (string, error) {
+	b := make([]byte, n)
+	for i := range b {
+		c, err := randomChar(chars)
+		if err != nil {
+			return "", err
+		}
+		b[i] = c
+	}
+	return string(b), nil
+}
+
+// Dump writes an archive of the SeedDatabase of the MongoDB at dbUri to
+// archivePath, which Restore can restore.
+func Dump(ctx context.Context, dbUri, archivePath string, copyIO bool) error {
+	cmd := exec.CommandContext(
+		ctx,
+		"mongodump",
+		"--uri="+dbUri,
+		"--db="+SeedDatabase,
+		"--archive="+archivePath,
+	)
+	if copyIO {
+		cmd.Stdout = os.Stdout
+		cmd.Stderr = os.Stderr
+	}
+
+	return cmd.Run()
+}
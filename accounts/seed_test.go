@@ -0,0 +1,148 @@
+package accounts
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// testMongoURI returns the URI of a scratch mongod to seed, given by
+// LOADCTL_TEST_MONGODB_URI, or skips the test. Its meteor and pearup
+// databases are overwritten.
+func testMongoURI(t *testing.T) string {
+	uri := os.Getenv("LOADCTL_TEST_MONGODB_URI")
+	if uri == "" {
+		t.Skip("LOADCTL_TEST_MONGODB_URI is not set")
+	}
+	return uri
+}
+
+var testClasses = []Classroom{
+	{
+		Prepared: true,
+		Name:     "class1",
+		Teacher:  Teacher{Email: "teacher1@test.pearup.de", Password: "Passwort123!"},
+		Pupils: []Pupil{
+			{Username: "pupil1t1", Password: "Passwort123!", Company: "company1t1"},
+			{Username: "pupil2t1", Password: "s3cret", Company: "company2t1"},
+		},
+	},
+	{
+		Prepared: true,
+		Name:     "class2",
+		Teacher:  Teacher{Email: "teacher2@test.pearup.de", Password: "Passwort123!"},
+	},
+	{
+		Prepared: false,
+		Name:     "class3",
+		Teacher:  Teacher{Email: "teacher3@test.pearup.de", Password: "Passwort123!"},
+		Pupils:   []Pupil{{Username: "pupil1t3", Password: "Passwort123!", Company: "company1t3"}},
+	},
+}
+
+func TestSeed(t *testing.T) {
+	uri := testMongoURI(t)
+	if _, err := exec.LookPath("mongodump"); err != nil {
+		t.Skip("mongodump is not installed")
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Minute)
+	defer cancel()
+
+	if err := Seed(ctx, uri, testClasses); err != nil {
+		t.Fatalf("Seed() error = %v", err)
+	}
+
+	client, err := mongo.Connect(ctx, options.Client().ApplyURI(uri))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Disconnect(context.Background())
+	db := client.Database(SeedDatabase)
+
+	// The unprepared class3 isn't seeded
+	for coll, want := range map[string]int64{usersCollection: 4, classesCollection: 2, companiesCollection: 2} {
+		n, err := db.Collection(coll).CountDocuments(ctx, bson.M{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if n != want {
+			t.Errorf("%s has %d documents, want %d", coll, n, want)
+		}
+	}
+
+	var pupil user
+	if err := db.Collection(usersCollection).FindOne(ctx, bson.M{"username": "pupil2t1"}).Decode(&pupil); err != nil {
+		t.Fatalf("pupil2t1 wasn't seeded: %v", err)
+	}
+	digest := sha256.Sum256([]byte("s3cret"))
+	if err := bcrypt.CompareHashAndPassword([]byte(pupil.Services.Password.Bcrypt), []byte(hex.EncodeToString(digest[:]))); err != nil {
+		t.Errorf("password of pupil2t1 doesn't authenticate: %v", err)
+	}
+	var cl class
+	if err := db.Collection(classesCollection).FindOne(ctx, bson.M{"_id": pupil.ClassId}).Decode(&cl); err != nil {
+		t.Fatalf("class of pupil2t1 wasn't seeded: %v", err)
+	}
+	if cl.Name != "class1" || len(cl.PupilIds) != 2 {
+		t.Errorf("class of pupil2t1 = %+v, want class1 with 2 pupils", cl)
+	}
+
+	archive := filepath.Join(t.TempDir(), "dump")
+	if err := Dump(ctx, uri, archive, false); err != nil {
+		t.Fatalf("Dump() error = %v", err)
+	}
+	if fi, err := os.Stat(archive); err != nil || fi.Size() == 0 {
+		t.Errorf("Dump() wrote no archive: %v", err)
+	}
+
+	mismatches, err := VerifyDump(archive, testClasses)
+	if err != nil {
+		t.Fatalf("VerifyDump() error = %v", err)
+	}
+	if len(mismatches) != 0 {
+		t.Errorf("VerifyDump() = %v, want no mismatches", mismatches)
+	}
+
+	drifted := make([]Classroom, len(testClasses))
+	copy(drifted, testClasses)
+	drifted[1].Teacher.Password = "wrong"
+	drifted[2].Prepared = true
+	mismatches, err = VerifyDump(archive, drifted)
+	if err != nil {
+		t.Fatalf("VerifyDump() error = %v", err)
+	}
+	// The wrong password, and the teacher and pupil of the class which wasn't seeded
+	if len(mismatches) != 3 {
+		t.Errorf("VerifyDump() = %v, want 3 mismatches", mismatches)
+	}
+
+	restored, err := RestoreNative(ctx, uri, archive, nil)
+	if err != nil {
+		t.Fatalf("RestoreNative() error = %v", err)
+	}
+	counts := make(map[string]int)
+	for _, r := range restored {
+		counts[r.Namespace] = r.Documents
+	}
+	for ns, want := range map[string]int{"pearup.users": 4, "pearup.classes": 2, "pearup.companies": 2} {
+		if counts[ns] != want {
+			t.Errorf("RestoreNative() restored %d documents into %s, want %d", counts[ns], ns, want)
+		}
+	}
+	mismatches, err = VerifyDatabase(ctx, uri, testClasses)
+	if err != nil {
+		t.Fatalf("VerifyDatabase() error = %v", err)
+	}
+	if len(mismatches) != 0 {
+		t.Errorf("VerifyDatabase() = %v, want no mismatches", mismatches)
+	}
+}
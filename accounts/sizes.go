@@ -0,0 +1,292 @@
+package accounts
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"math/rand"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// SizeDistribution describes the number of pupils of classes, either as a
+// fixed list of sizes, a uniform range or a histogram of weighted sizes.
+// Only one of them may be given.
+type SizeDistribution struct {
+	// Sizes are used in turn.
+	Sizes []int `json:"sizes,omitempty"`
+	// Min and Max are the bounds of a uniform distribution, both inclusive.
+	Min int `json:"min,omitempty"`
+	Max int `json:"max,omitempty"`
+	// Histogram maps sizes to their weights.
+	Histogram map[int]float64 `json:"histogram,omitempty"`
+}
+
+// FixedSize returns a distribution of classes which all have the same size.
+func FixedSize(size int) SizeDistribution {
+	return SizeDistribution{Sizes: []int{size}}
+}
+
+// IsZero reports whether no distribution has been given.
+func (d SizeDistribution) IsZero() bool {
+	return len(d.Sizes) == 0 && d.Min == 0 && d.Max == 0 && len(d.Histogram) == 0
+}
+
+// Validate checks that exactly one form of distribution with positive sizes is given.
+func (d SizeDistribution) Validate() error {
+	forms := 0
+	if len(d.Sizes) > 0 {
+		forms++
+		for _, s := range d.Sizes {
+			if s <= 0 {
+				return fmt.Errorf("class sizes should be positive, got %d", s)
+			}
+		}
+	}
+	if d.Min != 0 || d.Max != 0 {
+		forms++
+		if d.Min <= 0 || d.Max < d.Min {
+			return fmt.Errorf("class size range should be positive with min <= max, got [%d, %d]", d.Min, d.Max)
+		}
+	}
+	if len(d.Histogram) > 0 {
+		forms++
+		total := 0.0
+		for s, w := range d.Histogram {
+			if s <= 0 || w < 0 || math.IsNaN(w) || math.IsInf(w, 0) {
+				return fmt.Errorf("class size histogram should have positive sizes and finite, non-negative weights, got %d: %v", s, w)
+			}
+			total += w
+		}
+		// Without any weight, no classes would be apportioned at all
+		if total <= 0 {
+			return errors.New("class size histogram should have a positive total weight")
+		}
+	}
+
+	switch forms {
+	case 0:
+		return errors.New("no class sizes given")
+	case 1:
+		return nil
+	default:
+		return errors.New("class sizes should be given as either a list, a range or a histogram")
+	}
+}
+
+// Sample returns the sizes of n classes. Lists are repeated, ranges and
+// histograms are sampled from rng.
+func (d SizeDistribution) Sample(n int, rng *rand.Rand) []int {
+	sizes := make([]int, n)
+	switch {
+	case len(d.Sizes) > 0:
+		for i := range sizes {
+			sizes[i] = d.Sizes[i%len(d.Sizes)]
+		}
+	case len(d.Histogram) > 0:
+		keys, total := d.histogramSizes()
+		for i := range sizes {
+			r := rng.Float64() * total
+			for _, s := range keys {
+				r -= d.Histogram[s]
+				if r < 0 {
+					sizes[i] = s
+					break
+				}
+			}
+			if sizes[i] == 0 {
+				sizes[i] = keys[len(keys)-1]
+			}
+		}
+	default:
+		for i := range sizes {
+			sizes[i] = d.Min + rng.Intn(d.Max-d.Min+1)
+		}
+	}
+
+	return sizes
+}
+
+// histogramSizes returns the sizes of the histogram in ascending order and their total weight.
+func (d SizeDistribution) histogramSizes() ([]int, float64) {
+	keys := make([]int, 0, len(d.Histogram))
+	total := 0.0
+	for s, w := range d.Histogram {
+		keys = append(keys, s)
+		total += w
+	}
+	sort.Ints(keys)
+	return keys, total
+}
+
+// Largest returns the largest size of the distribution.
+func (d SizeDistribution) Largest() int {
+	largest := d.Max
+	for _, s := range d.Sizes {
+		if s > largest {
+			largest = s
+		}
+	}
+	for s := range d.Histogram {
+		if s > largest {
+			largest = s
+		}
+	}
+	return largest
+}
+
+// Set parses a distribution from a flag, either a range like 15-32, a list
+// like 25,30 or a histogram of weighted sizes like 25:1,30:3.
+func (d *SizeDistribution) Set(val string) error {
+	var parsed SizeDistribution
+	switch {
+	case strings.Contains(val, "-"):
+		bounds := strings.SplitN(val, "-", 2)
+		min, err := strconv.Atoi(strings.TrimSpace(bounds[0]))
+		if err != nil {
+			return fmt.Errorf("failed to parse lower bound of %s", val)
+		}
+		max, err := strconv.Atoi(strings.TrimSpace(bounds[1]))
+		if err != nil {
+			return fmt.Errorf("failed to parse upper bound of %s", val)
+		}
+		parsed.Min, parsed.Max = min, max
+	case strings.Contains(val, ":"):
+		parsed.Histogram = make(map[int]float64)
+		for _, v := range strings.Split(val, ",") {
+			parts := strings.SplitN(v, ":", 2)
+			if len(parts) != 2 {
+				return fmt.Errorf("failed to parse %s, expected size:weight", v)
+			}
+			size, err := strconv.Atoi(strings.TrimSpace(parts[0]))
+			if err != nil {
+				return fmt.Errorf("failed to parse size of %s", v)
+			}
+			weight, err := strconv.ParseFloat(strings.TrimSpace(parts[1]), 64)
+			if err != nil {
+				return fmt.Errorf("failed to parse weight of %s", v)
+			}
+			parsed.Histogram[size] = weight
+		}
+	default:
+		for _, v := range strings.Split(val, ",") {
+			size, err := strconv.Atoi(strings.TrimSpace(v))
+			if err != nil {
+				return fmt.Errorf("failed to parse class size %s", v)
+			}
+			parsed.Sizes = append(parsed.Sizes, size)
+		}
+	}
+
+	*d = parsed
+	return nil
+}
+
+func (d SizeDistribution) String() string {
+	switch {
+	case len(d.Sizes) > 0:
+		vals := make([]string, len(d.Sizes))
+		for i, s := range d.Sizes {
+			vals[i] = strconv.Itoa(s)
+		}
+		return strings.Join(vals, ",")
+	case len(d.Histogram) > 0:
+		keys, _ := d.histogramSizes()
+		vals := make([]string, len(keys))
+		for i, s := range keys {
+			vals[i] = fmt.Sprintf("%d:%g", s, d.Histogram[s])
+		}
+		return strings.Join(vals, ",")
+	case d.Min != 0 || d.Max != 0:
+		return fmt.Sprintf("%d-%d", d.Min, d.Max)
+	default:
+		return ""
+	}
+}
+
+// Demand is the number of prepared and unprepared classes a run needs of a size.
+type Demand struct {
+	Size       int
+	Prepared   int
+	Unprepared int
+}
+
+// Demands splits n classes into buckets of the same size following the
+// distribution, largest size first. Unlike Sample, the split is
+// deterministic: lists are repeated, ranges and histograms are split in
+// proportion to their weights. Prepared classes are spread evenly across
+// the buckets.
+func (d SizeDistribution) Demands(n int, preparedPortion float64) []Demand {
+	counts := make(map[int]int)
+	switch {
+	case len(d.Sizes) > 0:
+		for i := 0; i < n; i++ {
+			counts[d.Sizes[i%len(d.Sizes)]]++
+		}
+	case len(d.Histogram) > 0:
+		counts = apportion(n, d.Histogram)
+	case d.Min > 0 && d.Max >= d.Min:
+		weights := make(map[int]float64)
+		for s := d.Min; s <= d.Max; s++ {
+			weights[s] = 1
+		}
+		counts = apportion(n, weights)
+	}
+
+	sizes := make([]int, 0, len(counts))
+	for s, c := range counts {
+		if c > 0 {
+			sizes = append(sizes, s)
+		}
+	}
+	sort.Sort(sort.Reverse(sort.IntSlice(sizes)))
+
+	demands := make([]Demand, len(sizes))
+	cumulative, prepared := 0, 0
+	for i, s := range sizes {
+		cumulative += counts[s]
+		// Rounding the cumulative count keeps the total equal to NumPrepared(n, preparedPortion)
+		p := NumPrepared(cumulative, preparedPortion) - prepared
+		prepared += p
+		demands[i] = Demand{Size: s, Prepared: p, Unprepared: counts[s] - p}
+	}
+	return demands
+}
+
+// apportion splits n by the weights using the largest remainder method.
+func apportion(n int, weights map[int]float64) map[int]int {
+	keys := make([]int, 0, len(weights))
+	total := 0.0
+	for s, w := range weights {
+		keys = append(keys, s)
+		total += w
+	}
+	sort.Ints(keys)
+
+	counts := make(map[int]int, len(keys))
+	if total <= 0 {
+		return counts
+	}
+	remainders := make([]float64, len(keys))
+	assigned := 0
+	for i, s := range keys {
+		quota := float64(n) * weights[s] / total
+		counts[s] = int(quota)
+		remainders[i] = quota - float64(counts[s])
+		assigned += counts[s]
+	}
+
+	order := make([]int, len(keys))
+	for i := range order {
+		order[i] = i
+	}
+	sort.SliceStable(order, func(i, j int) bool {
+		return remainders[order[i]] > remainders[order[j]]
+	})
+	for i := 0; assigned < n; i++ {
+		counts[keys[order[i%len(order)]]]++
+		assigned++
+	}
+	return counts
+}
@@ -0,0 +1,278 @@
+package accounts
+
+import (
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// AccountSource provides the classes of the test accounts.
+type AccountSource interface {
+	Read(ctx context.Context) ([]Classroom, error)
+	// String describes the source for logs.
+	String() string
+}
+
+// NewFileSource reads the classes from a JSON file as Generate writes it.
+// Being written locally, its passwords may be given as file:<path> or
+// env:<name>.
+func NewFileSource(path string) AccountSource {
+	return &fileSource{path: path}
+}
+
+type fileSource struct {
+	path string
+}
+
+func (fs *fileSource) Read(ctx context.Context) ([]Classroom, error) {
+	b, err := ioutil.ReadFile(fs.path)
+	if err != nil {
+		return nil, err
+	}
+
+	var c []Classroom
+	if err := json.Unmarshal(b, &c); err != nil {
+		return nil, fmt.Errorf("malformed accounts file %s: %w", fs.path, err)
+	}
+	return c, resolvePasswords(c)
+}
+
+func (fs *fileSource) String() string {
+	return fs.path
+}
+
+// csvColumns are the columns a CSV file of accounts needs, in any order.
+var csvColumns = []string{"class", "prepared", "email", "teacherPassword", "username", "password", "company"}
+
+// NewCSVSource imports the classes from a CSV file with a header of the
+// csvColumns. Each row holds a pupil together with their class, rows of the
+// same teacher make up a class. A row without a username adds a class
+// without pupils.
+func NewCSVSource(path string) AccountSource {
+	return &csvSource{path: path}
+}
+
+type csvSource struct {
+	path string
+}
+
+func (cs *csvSource) Read(ctx context.Context) ([]Classroom, error) {
+	f, err := os.Open(cs.path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	r := csv.NewReader(f)
+	header, err := r.Read()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read header of %s: %w", cs.path, err)
+	}
+	col := make(map[string]int, len(header))
+	for i, h := range header {
+		col[h] = i
+	}
+	for _, c := range csvColumns {
+		if _, ok := col[c]; !ok {
+			return nil, fmt.Errorf("%s has no column %s", cs.path, c)
+		}
+	}
+
+	var classes []Classroom
+	byEmail := make(map[string]int)
+	for n := 1; ; n++ {
+		row, err := r.Read()
+		if err == io.EOF {
+			return classes, nil
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to read %s: %w", cs.path, err)
+		}
+
+		email := row[col["email"]]
+		i, ok := byEmail[email]
+		if !ok {
+			prepared, err := strconv.ParseBool(row[col["prepared"]])
+			if err != nil {
+				return nil, fmt.Errorf("row %d of %s: prepared should be a boolean: %w", n, cs.path, err)
+			}
+			i = len(classes)
+			byEmail[email] = i
+			classes = append(classes, Classroom{
+				Prepared: prepared,
+				Name:     row[col["class"]],
+				Teacher:  Teacher{Email: email, Password: row[col["teacherPassword"]]},
+			})
+		}
+
+		if username := row[col["username"]]; username != "" {
+			classes[i].Pupils = append(classes[i].Pupils, Pupil{
+				Username: username,
+				Password: row[col["password"]],
+				Company:  row[col["company"]],
+			})
+		}
+	}
+}
+
+func (cs *csvSource) String() string {
+	return cs.path
+}
+
+// NewMongoSource queries the classes from the database at dbUri, stored the
+// way Seed writes them. Only the classes of teachers with an email address of
+// the domain are test accounts, since the database may hold real users as
+// well. Since the database only holds password hashes, all accounts are
+// expected to share the password. All classes are prepared, as their
+// accounts already exist.
+func NewMongoSource(dbUri, domain, password string) AccountSource {
+	return &mongoSource{dbUri: dbUri, domain: domain, password: password}
+}
+
+type mongoSource struct {
+	dbUri    string
+	domain   string
+	password string
+}
+
+func (ms *mongoSource) Read(ctx context.Context) ([]Classroom, error) {
+	if ms.domain == "" {
+		return nil, errors.New("reading accounts from a database requires the domain of their emails")
+	}
+
+	client, err := mongo.Connect(ctx, options.Client().ApplyURI(ms.dbUri))
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to %s: %w", Database(ms.dbUri), err)
+	}
+	defer client.Disconnect(context.Background())
+
+	db := client.Database(renameNamespace(SeedDatabase))
+	var teachers []user
+	domain := bson.M{"$regex": "@" + regexp.QuoteMeta(ms.domain) + "$", "$options": "i"}
+	if err := findAll(ctx, db.Collection(usersCollection), bson.M{"emails.address": domain}, &teachers); err != nil {
+		return nil, err
+	}
+	// Empty, not nil, since $in needs an array
+	teacherIds := []string{}
+	for _, t := range teachers {
+		teacherIds = append(teacherIds, t.Id)
+	}
+
+	var cls []class
+	if err := findAll(ctx, db.Collection(classesCollection), bson.M{"teacherId": bson.M{"$in": teacherIds}}, &cls); err != nil {
+		return nil, err
+	}
+	pupilIds := []string{}
+	for _, c := range cls {
+		pupilIds = append(pupilIds, c.PupilIds...)
+	}
+
+	var pupils []user
+	if err := findAll(ctx, db.Collection(usersCollection), bson.M{"_id": bson.M{"$in": pupilIds}}, &pupils); err != nil {
+		return nil, err
+	}
+	var companies []company
+	if err := findAll(ctx, db.Collection(companiesCollection), bson.M{"ownerId": bson.M{"$in": pupilIds}}, &companies); err != nil {
+		return nil, err
+	}
+	usersById := make(map[string]user, len(teachers)+len(pupils))
+	for _, u := range append(teachers, pupils...) {
+		usersById[u.Id] = u
+	}
+	companiesByOwner := make(map[string]string, len(companies))
+	for _, c := range companies {
+		companiesByOwner[c.OwnerId] = c.Name
+	}
+
+	classes := make([]Classroom, 0, len(cls))
+	for _, c := range cls {
+		email := ms.email(usersById[c.TeacherId])
+		if email == "" {
+			continue
+		}
+		classroom := Classroom{
+			Prepared: true,
+			Name:     c.Name,
+			Teacher:  Teacher{Email: email, Password: ms.password},
+		}
+		for _, id := range c.PupilIds {
+			if pupil, ok := usersById[id]; ok && pupil.Username != "" {
+				classroom.Pupils = append(classroom.Pupils, Pupil{
+					Username: pupil.Username,
+					Password: ms.password,
+					Company:  companiesByOwner[id],
+				})
+			}
+		}
+		classes = append(classes, classroom)
+	}
+	return classes, nil
+}
+
+// email returns the address of the teacher within the domain.
+func (ms *mongoSource) email(teacher user) string {
+	for _, e := range teacher.Emails {
+		if strings.HasSuffix(strings.ToLower(e.Address), "@"+strings.ToLower(ms.domain)) {
+			return e.Address
+		}
+	}
+	return ""
+}
+
+func (ms *mongoSource) String() string {
+	return Database(ms.dbUri)
+}
+
+// NewHTTPSource fetches the classes as JSON, like the accounts file, from
+// url. A non-empty token is sent as bearer token.
+func NewHTTPSource(url, token string) AccountSource {
+	return &httpSource{url: url, token: token}
+}
+
+type httpSource struct {
+	url   string
+	token string
+}
+
+func (hs *httpSource) Read(ctx context.Context) ([]Classroom, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, hs.url, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", "application/json")
+	if hs.token != "" {
+		req.Header.Set("Authorization", "Bearer "+hs.token)
+	}
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch accounts: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("fetching accounts from %s failed with %s", hs.url, resp.Status)
+	}
+
+	var c []Classroom
+	if err := json.NewDecoder(resp.Body).Decode(&c); err != nil {
+		return nil, fmt.Errorf("malformed accounts from %s: %w", hs.url, err)
+	}
+	return c, nil
+}
+
+func (hs *httpSource) String() string {
+	return hs.url
+}
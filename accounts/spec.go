@@ -0,0 +1,274 @@
+package accounts
+
+import (
+	"bytes"
+	crand "crypto/rand"
+	"errors"
+	"fmt"
+	"math/big"
+	"math/rand"
+	"text/template"
+	"time"
+)
+
+// Spec describes the accounts to generate, so that they match what the
+// system under test validates. Templates are text/template strings which
+// are given the TemplateData of the account.
+type Spec struct {
+	// Locale selects the defaults of the class name and password, "de" or "en".
+	Locale        string `json:"locale,omitempty"`
+	ClassName     string `json:"className,omitempty"`
+	TeacherEmail  string `json:"teacherEmail,omitempty"`
+	PupilUsername string `json:"pupilUsername,omitempty"`
+	PupilCompany  string `json:"pupilCompany,omitempty"`
+
+	// Password is shared by all accounts, unless RandomPasswords is given.
+	Password        string          `json:"password,omitempty"`
+	RandomPasswords *PasswordPolicy `json:"randomPasswords,omitempty"`
+
+	ClassSizes SizeDistribution `json:"classSizes,omitempty"`
+	// ClassesPerTeacher makes teachers own several classes. Runs only use
+	// one class of each teacher, since a teacher can't be logged in twice.
+	ClassesPerTeacher int `json:"classesPerTeacher,omitempty"`
+	// Seed makes sampling class sizes reproducible, the current time is used if it is zero.
+	Seed int64 `json:"seed,omitempty"`
+}
+
+// PasswordPolicy generates a random password for each account.
+type PasswordPolicy struct {
+	Length int `json:"length"`
+	// Upper, Lower, Digits and Symbols are the minimum number of characters of each class.
+	Upper   int `json:"upper,omitempty"`
+	Lower   int `json:"lower,omitempty"`
+	Digits  int `json:"digits,omitempty"`
+	Symbols int `json:"symbols,omitempty"`
+}
+
+// TemplateData is passed to the templates of a Spec. All numbers start at one.
+type TemplateData struct {
+	// Class numbers all classes, ClassOfTeacher the classes of each teacher.
+	Class          int
+	Teacher        int
+	ClassOfTeacher int
+	// Pupil numbers the pupils of a class, it is zero for teacher and class templates.
+	Pupil int
+}
+
+type locale struct {
+	className string
+	password  string
+}
+
+var locales = map[string]locale{
+	"de": {className: defaultClassName, password: defaultPassword},
+	"en": {className: "TestClass", password: "Password123!"},
+}
+
+// withDefaults fills in all fields which haven't been given.
+func (s Spec) withDefaults() Spec {
+	if s.Locale == "" {
+		s.Locale = "de"
+	}
+	l := locales[s.Locale]
+	if s.ClassesPerTeacher <= 0 {
+		s.ClassesPerTeacher = 1
+	}
+	if s.ClassName == "" {
+		s.ClassName = l.className
+		if s.ClassesPerTeacher > 1 {
+			s.ClassName += " {{.ClassOfTeacher}}"
+		}
+	}
+	if s.TeacherEmail == "" {
+		s.TeacherEmail = "teacher-{{.Teacher}}@load-test.com"
+	}
+	if s.PupilUsername == "" {
+		s.PupilUsername = "pupil{{.Class}}t{{.Pupil}}"
+	}
+	if s.PupilCompany == "" {
+		s.PupilCompany = "company{{.Class}}t{{.Pupil}}"
+	}
+	if s.Password == "" {
+		s.Password = l.password
+	}
+	return s
+}
+
+// Validate checks the spec for problems before generating any accounts.
+func (s Spec) Validate() error {
+	if _, ok := locales[s.Locale]; s.Locale != "" && !ok {
+		return fmt.Errorf("unknown locale %q", s.Locale)
+	}
+	if s.ClassesPerTeacher < 0 {
+		return errors.New("classesPerTeacher should not be negative")
+	}
+	if err := s.ClassSizes.Validate(); err != nil {
+		return err
+	}
+	if p := s.RandomPasswords; p != nil {
+		if p.Length <= 0 || p.Upper < 0 || p.Lower < 0 || p.Digits < 0 || p.Symbols < 0 {
+			return errors.New("password length should be positive and minimum counts not negative")
+		}
+		if p.Upper+p.Lower+p.Digits+p.Symbols > p.Length {
+			return fmt.Errorf("password length %d is too short for the minimum counts", p.Length)
+		}
+	}
+
+	_, err := s.withDefaults().templates()
+	return err
+}
+
+type templates struct {
+	className, teacherEmail, pupilUsername, pupilCompany *template.Template
+}
+
+func (s Spec) templates() (*templates, error) {
+	var t templates
+	var err error
+	for _, tmpl := range []struct {
+		name string
+		text string
+		dst  **template.Template
+	}{
+		{"className", s.ClassName, &t.className},
+		{"teacherEmail", s.TeacherEmail, &t.teacherEmail},
+		{"pupilUsername", s.PupilUsername, &t.pupilUsername},
+		{"pupilCompany", s.PupilCompany, &t.pupilCompany},
+	} {
+		*tmpl.dst, err = template.New(tmpl.name).Option("missingkey=error").Parse(tmpl.text)
+		if err != nil {
+			return nil, fmt.Errorf("invalid %s template: %w", tmpl.name, err)
+		}
+		if _, err := execute(*tmpl.dst, TemplateData{1, 1, 1, 1}); err != nil {
+			return nil, fmt.Errorf("invalid %s template: %w", tmpl.name, err)
+		}
+	}
+	return &t, nil
+}
+
+func execute(t *template.Template, data TemplateData) (string, error) {
+	var b bytes.Buffer
+	if err := t.Execute(&b, data); err != nil {
+		return "", err
+	}
+	return b.String(), nil
+}
+
+// build returns the classes of the teachers, of which the first numPrepared
+// teachers' classes are prepared.
+func (s Spec) build(teachers, numPrepared int) ([]Classroom, error) {
+	s = s.withDefaults()
+	classes := teachers * s.ClassesPerTeacher
+	t, err := s.templates()
+	if err != nil {
+		return nil, err
+	}
+
+	seed := s.Seed
+	if seed == 0 {
+		seed = time.Now().UnixNano()
+	}
+	sizes := s.ClassSizes.Sample(classes, rand.New(rand.NewSource(seed)))
+
+	result := make([]Classroom, classes)
+	for i := range result {
+		data := TemplateData{
+			Class:          i + 1,
+			Teacher:        i/s.ClassesPerTeacher + 1,
+			ClassOfTeacher: i%s.ClassesPerTeacher + 1,
+		}
+		c := Classroom{Prepared: data.Teacher <= numPrepared}
+		if c.Name, err = execute(t.className, data); err != nil {
+			return nil, err
+		}
+		if c.Email, err = execute(t.teacherEmail, data); err != nil {
+			return nil, err
+		}
+		if c.Teacher.Password, err = s.password(); err != nil {
+			return nil, err
+		}
+
+		c.Pupils = make([]Pupil, sizes[i])
+		for j := range c.Pupils {
+			data.Pupil = j + 1
+			p := &c.Pupils[j]
+			if p.Username, err = execute(t.pupilUsername, data); err != nil {
+				return nil, err
+			}
+			if p.Company, err = execute(t.pupilCompany, data); err != nil {
+				return nil, err
+			}
+			if p.Password, err = s.password(); err != nil {
+				return nil, err
+			}
+		}
+		result[i] = c
+	}
+
+	// Teachers of several classes share a single password
+	if s.ClassesPerTeacher > 1 {
+		for i := range result {
+			result[i].Teacher.Password = result[i-i%s.ClassesPerTeacher].Teacher.Password
+		}
+	}
+
+	return result, nil
+}
+
+const (
+	upperChars  = "ABCDEFGHIJKLMNOPQRSTUVWXYZ"
+	lowerChars  = "abcdefghijklmnopqrstuvwxyz"
+	digitChars  = "0123456789"
+	symbolChars = "!#$%&*+-=?@_"
+)
+
+// password returns the shared password or a random one following the policy.
+func (s Spec) password() (string, error) {
+	p := s.RandomPasswords
+	if p == nil {
+		return s.Password, nil
+	}
+
+	var chars []byte
+	for _, class := range []struct {
+		chars string
+		min   int
+	}{{upperChars, p.Upper}, {lowerChars, p.Lower}, {digitChars, p.Digits}, {symbolChars, p.Symbols}} {
+		for i := 0; i < class.min; i++ {
+			c, err := randomChar(class.chars)
+			if err != nil {
+				return "", err
+			}
+			chars = append(chars, c)
+		}
+	}
+	all := upperChars + lowerChars + digitChars
+	if p.Symbols > 0 {
+		all += symbolChars
+	}
+	for len(chars) < p.Length {
+		c, err := randomChar(all)
+		if err != nil {
+			return "", err
+		}
+		chars = append(chars, c)
+	}
+
+	// Shuffle, so that the required characters aren't always up front
+	for i := len(chars) - 1; i > 0; i-- {
+		j, err := crand.Int(crand.Reader, big.NewInt(int64(i+1)))
+		if err != nil {
+			return "", err
+		}
+		chars[i], chars[j.Int64()] = chars[j.Int64()], chars[i]
+	}
+	return string(chars), nil
+}
+
+func randomChar(chars string) (byte, error) {
+	i, err := crand.Int(crand.Reader, big.NewInt(int64(len(chars))))
+	if err != nil {
+		return 0, err
+	}
+	return chars[i.Int64()], nil
+}
@@ -0,0 +1,195 @@
+package accounts
+
+import (
+	"context"
+	"fmt"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// Mismatch is an account whose state in a database differs from the accounts file.
+type Mismatch struct {
+	Account string
+	Problem string
+}
+
+func (m Mismatch) String() string {
+	return m.Account + ": " + m.Problem
+}
+
+// seeded holds the users, classes and companies of a database as Seed writes them.
+type seeded struct {
+	users     []user
+	classes   []class
+	companies []company
+}
+
+// VerifyDump checks the classes against the dump at path, an archive or a
+// directory of fixtures as RestoreNative reads them. See verify.
+func VerifyDump(path string, classes []Classroom) ([]Mismatch, error) {
+	var s seeded
+	err := readSeedDump(path, func(coll string, doc bson.Raw) error {
+		var err error
+		switch coll {
+		case usersCollection:
+			var u user
+			err = bson.Unmarshal(doc, &u)
+			s.users = append(s.users, u)
+		case classesCollection:
+			var c class
+			err = bson.Unmarshal(doc, &c)
+			s.classes = append(s.classes, c)
+		case companiesCollection:
+			var c company
+			err = bson.Unmarshal(doc, &c)
+			s.companies = append(s.companies, c)
+		}
+		if err != nil {
+			return fmt.Errorf("malformed document in %s: %w", coll, err)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return verify(&s, classes), nil
+}
+
+// VerifyDatabase checks the classes against the restored database at dbUri. See verify.
+func VerifyDatabase(ctx context.Context, dbUri string, classes []Classroom) ([]Mismatch, error) {
+	client, err := mongo.Connect(ctx, options.Client().ApplyURI(dbUri))
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to %s: %w", Database(dbUri), err)
+	}
+	defer client.Disconnect(context.Background())
+
+	db := client.Database(renameNamespace(SeedDatabase))
+	var s seeded
+	if err := findAll(ctx, db.Collection(usersCollection), newScope(classes).usersFilter(), &s.users); err != nil {
+		return nil, err
+	}
+
+	ids := make([]string, len(s.users))
+	for i, u := range s.users {
+		ids[i] = u.Id
+	}
+	if err := findAll(ctx, db.Collection(classesCollection), bson.M{"teacherId": bson.M{"$in": ids}}, &s.classes); err != nil {
+		return nil, err
+	}
+	if err := findAll(ctx, db.Collection(companiesCollection), bson.M{"ownerId": bson.M{"$in": ids}}, &s.companies); err != nil {
+		return nil, err
+	}
+
+	return verify(&s, classes), nil
+}
+
+func findAll(ctx context.Context, coll *mongo.Collection, filter interface{}, results interface{}) error {
+	cur, err := coll.Find(ctx, filter)
+	if err != nil {
+		return fmt.Errorf("failed to query %s: %w", coll.Name(), err)
+	}
+	if err := cur.All(ctx, results); err != nil {
+		return fmt.Errorf("failed to read %s: %w", coll.Name(), err)
+	}
+	return nil
+}
+
+// verify checks that the teachers and pupils of prepared classes exist,
+// authenticate with their passwords and have their class and companies,
+// and that the accounts of unprepared classes don't exist yet, since they
+// sign up during a run.
+func verify(s *seeded, classes []Classroom) []Mismatch {
+	byEmail := make(map[string]user)
+	byUsername := make(map[string]user)
+	for _, u := range s.users {
+		for _, e := range u.Emails {
+			byEmail[e.Address] = u
+		}
+		if u.Username != "" {
+			byUsername[u.Username] = u
+		}
+	}
+	classesById := make(map[string]class)
+	for _, c := range s.classes {
+		classesById[c.Id] = c
+	}
+	companiesByOwner := make(map[string]company)
+	for _, c := range s.companies {
+		companiesByOwner[c.OwnerId] = c
+	}
+
+	passwords := make(passwordChecker)
+	var mismatches []Mismatch
+	add := func(account, format string, a ...interface{}) {
+		mismatches = append(mismatches, Mismatch{Account: account, Problem: fmt.Sprintf(format, a...)})
+	}
+
+	for _, c := range classes {
+		teacher, ok := byEmail[c.Email]
+		if !c.Prepared {
+			if ok {
+				add(c.Email, "teacher of an unprepared class already exists and can't sign up")
+			}
+			for _, p := range c.Pupils {
+				if _, ok := byUsername[p.Username]; ok {
+					add(p.Username, "pupil of an unprepared class already exists and can't sign up")
+				}
+			}
+			continue
+		}
+
+		if !ok {
+			add(c.Email, "teacher of a prepared class doesn't exist")
+		} else if !passwords.check(teacher.Services.Password.Bcrypt, c.Teacher.Password) {
+			add(c.Email, "password doesn't authenticate")
+		}
+		cl, hasClass := classesById[teacher.ClassId]
+		hasClass = ok && hasClass && cl.TeacherId == teacher.Id
+		if ok && !hasClass {
+			add(c.Email, "has no class")
+		} else if hasClass && cl.Name != c.Name {
+			add(c.Email, "class is named %q instead of %q", cl.Name, c.Name)
+		}
+
+		for _, p := range c.Pupils {
+			pupil, ok := byUsername[p.Username]
+			if !ok {
+				add(p.Username, "pupil of a prepared class doesn't exist")
+				continue
+			}
+			if !passwords.check(pupil.Services.Password.Bcrypt, p.Password) {
+				add(p.Username, "password doesn't authenticate")
+			}
+			if hasClass && pupil.ClassId != cl.Id {
+				add(p.Username, "isn't a member of the class of %s", c.Email)
+			}
+			if comp, ok := companiesByOwner[pupil.Id]; !ok {
+				add(p.Username, "has no company")
+			} else if comp.Name != p.Company {
+				add(p.Username, "company is named %q instead of %q", comp.Name, p.Company)
+			}
+		}
+	}
+
+	return mismatches
+}
+
+// passwordChecker checks passwords against Meteor password hashes. Results
+// are reused, since bcrypt is deliberately slow and most accounts share a
+// password and its hash.
+type passwordChecker map[[2]string]bool
+
+func (c passwordChecker) check(hash, password string) bool {
+	key := [2]string{hash, password}
+	if ok, checked := c[key]; checked {
+		return ok
+	}
+
+	ok := bcrypt.CompareHashAndPassword([]byte(hash), meteorDigest(password)) == nil
+	c[key] = ok
+	return ok
+}
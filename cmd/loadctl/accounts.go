@@ -0,0 +1,200 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/DerGut/load-tests/accounts"
+	"github.com/DerGut/load-tests/cmd/loadctl/config"
+)
+
+func generateAccounts(fs *flag.FlagSet, args []string) error {
+	specFile := fs.String("spec", "", "Path to a json, yaml or toml file with the generation spec.")
+	conf, err := config.Parse(fs, args)
+	if err != nil {
+		return err
+	}
+
+	spec := accounts.Spec{ClassSizes: conf.Sizes()}
+	if *specFile != "" {
+		spec = accounts.Spec{}
+		if err := config.DecodeFile(*specFile, &spec); err != nil {
+			return fmt.Errorf("couldn't read spec: %w", err)
+		}
+		if spec.ClassSizes.IsZero() {
+			spec.ClassSizes = conf.Sizes()
+		}
+	}
+
+	total, portion := conf.LoadLevels.Total(), conf.PreparedPortion.Value()
+	if total <= 0 || portion < 0 || portion > 1 {
+		return fmt.Errorf("loadLevels need to be positive, preparedPortion within [0, 1]: %w", errUsage)
+	}
+	if err := spec.Validate(); err != nil {
+		return fmt.Errorf("invalid spec, classSize or classSizes need to be given: %w", err)
+	}
+
+	path, ok := conf.AccountsFile()
+	if !ok {
+		return fmt.Errorf("accounts can only be generated into a .json file, not %s: %w", conf.Accounts, errUsage)
+	}
+
+	log.Println("Generating new accounts file", path, total, portion)
+	if err := accounts.Generate(path, total, portion, spec); err != nil {
+		return fmt.Errorf("failed to generate accounts file: %w", err)
+	}
+
+	log.Println("A new accounts file has been created. Please seed a local mongod with it by running loadctl accounts seed")
+	return nil
+}
+
+func seedAccounts(fs *flag.FlagSet, args []string) error {
+	seedUri := fs.String("seedUri", "mongodb://localhost:27017", "URI of the scratch MongoDB to seed and dump, its "+accounts.SeedDatabase+" database is overwritten.")
+	conf, err := config.Parse(fs, args)
+	if err != nil {
+		return err
+	}
+
+	classes, err := readAccounts(conf)
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Minute)
+	defer cancel()
+
+	log.Println("Seeding", accounts.Database(*seedUri), "with", len(classes), "classes")
+	if err := accounts.Seed(ctx, *seedUri, classes); err != nil {
+		return fmt.Errorf("failed to seed accounts: %w", err)
+	}
+
+	log.Println("Dumping seeded accounts to", conf.Dump)
+	if err := accounts.Dump(ctx, *seedUri, conf.Dump, conf.Debug.Value()); err != nil {
+		return fmt.Errorf("failed to dump accounts: %w", err)
+	}
+
+	return nil
+}
+
+func restoreAccounts(fs *flag.FlagSet, args []string) error {
+	conf, err := config.Parse(fs, args)
+	if err != nil {
+		return err
+	}
+	if conf.DbUri == "" {
+		return fmt.Errorf("dbUri is required: %w", errUsage)
+	}
+
+	if conf.Accounts == config.AccountsMongo {
+		return fmt.Errorf("accounts from %s can't be restored: %w", config.AccountsMongo, errUsage)
+	}
+
+	var classes []accounts.Classroom
+	if conf.ResetMode() == config.ResetScoped {
+		if classes, err = unleasedAccounts(conf); err != nil {
+			return err
+		}
+	}
+	return resetDatabase(conf, "", classes)
+}
+
+// unleasedAccounts returns the classes which no run holds a lease on for
+// the database, whose documents can be reset without disturbing any run.
+func unleasedAccounts(conf *config.Config) ([]accounts.Classroom, error) {
+	classes, err := readAccounts(conf)
+	if err != nil {
+		return nil, err
+	}
+	leases, err := accounts.Leases(leaseFile)
+	if err != nil {
+		return nil, fmt.Errorf("couldn't read leases: %w", err)
+	}
+
+	leased := make(map[string]bool)
+	for _, l := range leases {
+		if l.Database == accounts.Database(conf.DbUri) {
+			leased[l.Teacher] = true
+		}
+	}
+	unleased := classes[:0]
+	for _, c := range classes {
+		if !leased[c.Email] {
+			unleased = append(unleased, c)
+		}
+	}
+	if n := len(classes) - len(unleased); n > 0 {
+		log.Println("Skipping", n, "classes leased by runs")
+	}
+	return unleased, nil
+}
+
+func verifyAccounts(fs *flag.FlagSet, args []string) error {
+	live := fs.Bool("live", false, "Verify the restored database at dbUri instead of the dump.")
+	conf, err := config.Parse(fs, args)
+	if err != nil {
+		return err
+	}
+	if *live && conf.DbUri == "" {
+		return fmt.Errorf("dbUri is required with --live: %w", errUsage)
+	}
+
+	var problems []string
+	if _, err := getAccounts(conf); err != nil {
+		problems = append(problems, err.Error())
+	} else {
+		log.Println("Accounts suffice for", conf.LoadLevels.Total(), "classes of size", conf.Sizes().String())
+	}
+
+	classes, err := readAccounts(conf)
+	if err != nil {
+		return err
+	}
+
+	var mismatches []accounts.Mismatch
+	if *live {
+		log.Println("Verifying accounts against", accounts.Database(conf.DbUri))
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Minute)
+		defer cancel()
+		mismatches, err = accounts.VerifyDatabase(ctx, conf.DbUri, classes)
+	} else {
+		log.Println("Verifying accounts against", conf.Dump)
+		mismatches, err = accounts.VerifyDump(conf.Dump, classes)
+	}
+	if err != nil {
+		return err
+	}
+	for _, m := range mismatches {
+		problems = append(problems, m.String())
+	}
+
+	if len(problems) > 0 {
+		return fmt.Errorf("accounts don't match:\n\t%s", strings.Join(problems, "\n\t"))
+	}
+	log.Println("All", len(classes), "classes of", conf.AccountSource(), "match")
+	return nil
+}
+
+func listLeases(fs *flag.FlagSet, args []string) error {
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	leases, err := accounts.Leases(leaseFile)
+	if err != nil {
+		return err
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "TEACHER\tRUN\tRUNNER\tDATABASE\tEXPIRES")
+	for _, l := range leases {
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\n", l.Teacher, l.Run, l.Runner, l.Database, l.Expires.Format(time.RFC1123))
+	}
+
+	return w.Flush()
+}
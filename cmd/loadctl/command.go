@@ -0,0 +1,63 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// errUsage signals that a command has been invoked with the wrong arguments.
+var errUsage = errors.New("invalid usage")
+
+// command is a node of loadctl's command tree. A command either has a
+// run func or subcommands.
+type command struct {
+	name        string
+	args        string
+	description string
+	run         func(fs *flag.FlagSet, args []string) error
+	subcommands []*command
+}
+
+func (c *command) execute(path []string, args []string) error {
+	path = append(path, c.name)
+
+	if len(c.subcommands) == 0 {
+		fs := flag.NewFlagSet(strings.Join(path, " "), flag.ContinueOnError)
+		fs.Usage = func() {
+			fmt.Fprintf(fs.Output(), "Usage: %s [flags] %s\n\n%s\n\nFlags:\n", fs.Name(), c.args, c.description)
+			fs.PrintDefaults()
+		}
+		err := c.run(fs, args)
+		if errors.Is(err, flag.ErrHelp) {
+			return nil
+		}
+		if errors.Is(err, errUsage) {
+			fs.Usage()
+		}
+		return err
+	}
+
+	if len(args) == 0 || args[0] == "-h" || args[0] == "--help" || args[0] == "help" {
+		c.usage(path)
+		return nil
+	}
+
+	for _, sub := range c.subcommands {
+		if sub.name == args[0] {
+			return sub.execute(path, args[1:])
+		}
+	}
+
+	c.usage(path)
+	return fmt.Errorf("unknown command %q: %w", strings.Join(append(path, args[0]), " "), errUsage)
+}
+
+func (c *command) usage(path []string) {
+	fmt.Fprintf(os.Stderr, "Usage: %s <command>\n\nCommands:\n", strings.Join(path, " "))
+	for _, sub := range c.subcommands {
+		fmt.Fprintf(os.Stderr, "  %-10s %s\n", sub.name, sub.description)
+	}
+}
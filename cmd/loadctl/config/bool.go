@@ -0,0 +1,59 @@
+package config
+
+import (
+	"encoding/json"
+	"strconv"
+)
+
+// Bool is a boolean which remembers whether it has been set at all. This
+// allows a later config source to overwrite a true value of an earlier
+// one with false, while an unset value leaves it untouched.
+type Bool struct {
+	set   bool
+	value bool
+}
+
+func NewBool(value bool) Bool {
+	return Bool{set: true, value: value}
+}
+
+// Value returns the boolean value, defaulting to false when unset.
+func (b Bool) Value() bool {
+	return b.value
+}
+
+func (b Bool) IsSet() bool {
+	return b.set
+}
+
+// Set implements flag.Value.
+func (b *Bool) Set(s string) error {
+	v, err := strconv.ParseBool(s)
+	if err != nil {
+		return err
+	}
+	*b = NewBool(v)
+	return nil
+}
+
+func (b *Bool) String() string {
+	return strconv.FormatBool(b.value)
+}
+
+// IsBoolFlag allows the flag to be passed without an explicit value.
+func (b *Bool) IsBoolFlag() bool {
+	return true
+}
+
+func (b Bool) MarshalJSON() ([]byte, error) {
+	return json.Marshal(b.value)
+}
+
+func (b *Bool) UnmarshalJSON(data []byte) error {
+	var v bool
+	if err := json.Unmarshal(data, &v); err != nil {
+		return err
+	}
+	*b = NewBool(v)
+	return nil
+}
@@ -1,218 +1,447 @@
 package config
 
 import (
-	"encoding/json"
 	"flag"
-	"io/ioutil"
-	"log"
+	"fmt"
+	"net/url"
 	"os"
-	"strconv"
-	"time"
+	"path/filepath"
+	"strings"
 
+	"github.com/DerGut/load-tests/accounts"
 	"github.com/DerGut/load-tests/controller"
+	"github.com/DerGut/load-tests/controller/provisioner"
+	"github.com/DerGut/load-tests/controller/runner"
+	"github.com/DerGut/load-tests/secrets"
 )
 
+// Ways to reset the database.
+const (
+	ResetFull   = "full"
+	ResetScoped = "scoped"
+	ResetNone   = "none"
+)
+
+// Ways to restore the dump.
+const (
+	RestoreMongorestore = "mongorestore"
+	RestoreNative       = "native"
+)
+
+// AccountsMongo selects the accounts in the database at DbUri as account source.
+const AccountsMongo = "mongodb"
+
 // Config captures all configuration provided by a config file,
 // env vars and command line args. Parameters provided via env vars
 // overwrite those provided by a file. Parameters provided via command
 // line args overwrite both.
-// This is with the exception of the boolean variables NoReset and Local.
-// A true value always wins in that case.
 type Config struct {
-	Url string `json:"url"`
-
-	NoReset bool   `json:"noReset"`
-	DbUri   string `json:"dbUri"`
-
-	LoadLevels      controller.LoadLevels `json:"loadLevels"`
-	StepSize        controller.StepSize   `json:"stepSize"`
-	ClassSize       int                   `json:"classSize"`
-	PreparedPortion float64               `json:"preparedPortion"`
+	Environment
+	Scenario
+}
 
-	Local            bool   `json:"local"`
-	ClassesPerRunner int    `json:"classesPerRunner"`
+// Environment captures the infrastructure the load test is run on.
+type Environment struct {
+	Local            Bool `json:"local"`
+	ClassesPerRunner int  `json:"classesPerRunner"`
+	// ContainersPerRunner spreads the classes of a runner instance across
+	// several runner containers, which share the instance equally.
+	ContainersPerRunner int `json:"containersPerRunner"`
+	// VuMemoryMB and VuCpus estimate what a virtual user needs. If given,
+	// classes are packed onto runners by their cost and the size of the
+	// instances instead of by ClassesPerRunner. Unprepared classes cost
+	// UnpreparedFactor times as much.
+	VuMemoryMB       Float  `json:"vuMemoryMB"`
+	VuCpus           Float  `json:"vuCpus"`
+	UnpreparedFactor Float  `json:"unpreparedFactor"`
 	DdApiKey         string `json:"ddApiKey"`
 	DoApiKey         string `json:"doApiKey"`
 	DoRegion         string `json:"doRegion"`
-	DoSize           string `json:"doSize"`
+	// DoRegions spreads the runner instances across several regions by
+	// their weights, e.g. {fra1: 70, ams3: 30}. It takes precedence over
+	// DoRegion.
+	DoRegions provisioner.Regions `json:"doRegions,omitempty"`
+	DoSize    string              `json:"doSize"`
+	// DoImage is the ID of a snapshot or the slug of a public image to boot
+	// the runner instances from. Defaults to the image recorded by
+	// `loadctl image build`, if any.
+	DoImage string `json:"doImage"`
+
+	// RunnerImage and AgentImage are resolved to their digest at the start of a run.
+	RunnerImage string `json:"runnerImage"`
+	AgentImage  string `json:"agentImage"`
+
+	// MaxInstances and MaxCost (in USD) limit the resources of remote runs.
+	MaxInstances Int   `json:"maxInstances"`
+	MaxCost      Float `json:"maxCost"`
+
+	Debug Bool `json:"debug"`
+}
+
+// Scenario captures the system under test and the load put onto it.
+type Scenario struct {
+	Url string `json:"url"`
 
-	Debug bool `json:"debug"`
+	// Reset selects how the database is reset before a run: the whole dump
+	// is restored, only the documents of the test accounts are, or nothing
+	// is. NoReset is kept as a shorthand for none.
+	Reset   string `json:"reset"`
+	NoReset Bool   `json:"noReset"`
+	DbUri   string `json:"dbUri"`
+	// Restore selects how the dump is restored, with the mongorestore
+	// binary or natively with the Go driver. Dump is the mongodump archive
+	// or, for native restores only, a directory of fixtures.
+	Restore string `json:"restore"`
+	Dump    string `json:"dump"`
+	// Reconcile compares what the runners submitted with the database at
+	// DbUri after the run.
+	Reconcile Bool `json:"reconcile"`
+
+	// Accounts selects where the test accounts come from: a JSON file, a
+	// CSV file, AccountsMongo or an http(s) URL. The accounts of the
+	// database are those of teachers with emails of AccountsDomain, which
+	// all share AccountsPassword, since the database only holds their
+	// hashes. AccountsToken authenticates requests to the URL.
+	Accounts         string `json:"accounts"`
+	AccountsDomain   string `json:"accountsDomain"`
+	AccountsPassword string `json:"accountsPassword"`
+	AccountsToken    string `json:"accountsToken"`
+
+	LoadLevels controller.LoadLevels `json:"loadLevels"`
+	StepSize   controller.StepSize   `json:"stepSize"`
+	ClassSize  int                   `json:"classSize"`
+	// ClassSizes varies the size of classes within a run, it takes
+	// precedence over ClassSize.
+	ClassSizes      accounts.SizeDistribution `json:"classSizes,omitempty"`
+	PreparedPortion Float                     `json:"preparedPortion"`
 }
 
-func Parse() *Config {
+// Parse registers all config flags with fs and parses args. It then merges
+// the config file, env vars and flags in that order. The result is not
+// validated, positional args remain available through fs.Args().
+func Parse(fs *flag.FlagSet, args []string) (*Config, error) {
+	f := registerFlags(fs)
+	if err := fs.Parse(args); err != nil {
+		return nil, err
+	}
+
 	c := defaultConfig()
-	c.merge(parseConfigFile(configFile))
-	c.merge(parseEnvVars())
-	c.merge(parseFlags())
 
-	validate(c)
+	env, err := parseEnvVars()
+	if err != nil {
+		return nil, fmt.Errorf("couldn't parse env vars: %w", err)
+	}
+
+	name := firstNonEmpty(f.envName, os.Getenv(envPrefix+"ENV"))
+	scenario := firstNonEmpty(f.scenarioName, os.Getenv(envPrefix+"SCENARIO"))
+	if err := c.mergeFile(f.configFile, name, scenario); err != nil {
+		return nil, fmt.Errorf("couldn't load config file: %w", err)
+	}
+	c.merge(env)
+	c.merge(&f.Config)
+
+	if err := c.resolveSecrets(); err != nil {
+		return nil, err
+	}
 
-	return c
+	return c, nil
 }
 
-func (c *Config) merge(other *Config) {
-	if other.Url != "" {
-		c.Url = other.Url
+// resolveSecrets reads the API keys and the credentials of the account
+// source given as file:<path> or env:<name>. The password of the database
+// URI is registered as a secret as well.
+func (c *Config) resolveSecrets() error {
+	if u, err := url.Parse(c.DbUri); err == nil {
+		if password, ok := u.User.Password(); ok {
+			secrets.Register(password)
+		}
 	}
 
-	c.NoReset = c.NoReset || other.NoReset
-	if other.DbUri != "" {
-		c.DbUri = other.DbUri
+	for _, s := range []struct {
+		name string
+		val  *string
+	}{
+		{"doApiKey", &c.DoApiKey},
+		{"ddApiKey", &c.DdApiKey},
+		{"accountsPassword", &c.AccountsPassword},
+		{"accountsToken", &c.AccountsToken},
+	} {
+		v, err := secrets.Resolve(*s.val)
+		if err != nil {
+			return fmt.Errorf("couldn't resolve %s: %w", s.name, err)
+		}
+		*s.val = v
 	}
+	return nil
+}
 
-	if other.LoadLevels != nil {
-		c.LoadLevels = other.LoadLevels
+func (c *Config) merge(other *Config) {
+	c.Environment.merge(&other.Environment)
+	c.Scenario.merge(&other.Scenario)
+}
+
+func (e *Environment) merge(other *Environment) {
+	if other.Local.IsSet() {
+		e.Local = other.Local
 	}
-	if other.StepSize.Duration > 0 {
-		c.StepSize = other.StepSize
+	if other.ClassesPerRunner > 0 {
+		e.ClassesPerRunner = other.ClassesPerRunner
 	}
-	if other.ClassSize > 0 {
-		c.ClassSize = other.ClassSize
+	if other.ContainersPerRunner > 0 {
+		e.ContainersPerRunner = other.ContainersPerRunner
 	}
-	if other.PreparedPortion > 0 {
-		c.PreparedPortion = other.PreparedPortion
+	if other.VuMemoryMB.IsSet() {
+		e.VuMemoryMB = other.VuMemoryMB
 	}
-
-	c.Local = c.Local || other.Local
-	if other.ClassesPerRunner > 0 {
-		c.ClassesPerRunner = other.ClassesPerRunner
+	if other.VuCpus.IsSet() {
+		e.VuCpus = other.VuCpus
+	}
+	if other.UnpreparedFactor.IsSet() {
+		e.UnpreparedFactor = other.UnpreparedFactor
 	}
 	if other.DoApiKey != "" {
-		c.DoApiKey = other.DoApiKey
+		e.DoApiKey = other.DoApiKey
 	}
 	if other.DdApiKey != "" {
-		c.DdApiKey = other.DdApiKey
+		e.DdApiKey = other.DdApiKey
 	}
 	if other.DoRegion != "" {
-		c.DoRegion = other.DoRegion
+		e.DoRegion = other.DoRegion
+	}
+	if len(other.DoRegions) > 0 {
+		e.DoRegions = other.DoRegions
 	}
 	if other.DoSize != "" {
-		c.DoSize = other.DoSize
+		e.DoSize = other.DoSize
+	}
+	if other.DoImage != "" {
+		e.DoImage = other.DoImage
+	}
+	if other.RunnerImage != "" {
+		e.RunnerImage = other.RunnerImage
+	}
+	if other.AgentImage != "" {
+		e.AgentImage = other.AgentImage
+	}
+	if other.MaxInstances.IsSet() {
+		e.MaxInstances = other.MaxInstances
+	}
+	if other.MaxCost.IsSet() {
+		e.MaxCost = other.MaxCost
 	}
 
-	c.Debug = c.Debug || other.Debug
+	if other.Debug.IsSet() {
+		e.Debug = other.Debug
+	}
 }
 
-var (
-	configFile string
-
-	url string
-
-	noReset         bool
-	dbUri           string
-	loadLevels      controller.LoadLevels
-	stepSize        time.Duration
-	classSize       int
-	preparedPortion float64
-
-	local            bool
-	classesPerRunner int
-	doApiKey         string
-	ddApiKey         string
-	doRegion         string
-	doSize           string
-
-	debug bool
-)
-
-func init() {
-	flag.StringVar(&configFile, "config", "", "Path to a json config file.")
-
-	flag.StringVar(&url, "url", "", "The URL to the system under test.")
+func (s *Scenario) merge(other *Scenario) {
+	if other.Url != "" {
+		s.Url = other.Url
+	}
 
-	flag.BoolVar(&noReset, "noReset", false, "Whether to skip the reset of the mongo instance.")
-	flag.StringVar(&dbUri, "dbUri", "", "The URI to the mongo instance.")
+	if other.Reset != "" {
+		s.Reset = other.Reset
+	}
+	if other.NoReset.IsSet() {
+		s.NoReset = other.NoReset
+	}
+	if other.DbUri != "" {
+		s.DbUri = other.DbUri
+	}
+	if other.Restore != "" {
+		s.Restore = other.Restore
+	}
+	if other.Dump != "" {
+		s.Dump = other.Dump
+	}
+	if other.Reconcile.IsSet() {
+		s.Reconcile = other.Reconcile
+	}
 
-	flag.Var(&loadLevels, "loadLevels", "A comma-separated list of class concurrencies.")
-	flag.DurationVar(&stepSize, "stepSize", 0, "time between each step of the load curve.")
-	flag.IntVar(&classSize, "classSize", 0, "The number of pupils within a class.")
-	flag.Float64Var(&preparedPortion, "preparedPortion", 0, "The portion of classes for which accounts should be created beforehand.")
+	if other.Accounts != "" {
+		s.Accounts = other.Accounts
+	}
+	if other.AccountsDomain != "" {
+		s.AccountsDomain = other.AccountsDomain
+	}
+	if other.AccountsPassword != "" {
+		s.AccountsPassword = other.AccountsPassword
+	}
+	if other.AccountsToken != "" {
+		s.AccountsToken = other.AccountsToken
+	}
 
-	flag.BoolVar(&local, "local", false, "If true, the tests will be run locally.")
-	flag.IntVar(&classesPerRunner, "classesPerRunner", 0, "The number of classes managed by a single runner instance.")
-	flag.StringVar(&doApiKey, "doApiKey", "", "The API key for digital ocean.")
-	flag.StringVar(&ddApiKey, "ddApiKey", "", "The API key for datadog.")
-	flag.StringVar(&doRegion, "doRegion", "", "The region to provision the runner instances in.")
-	flag.StringVar(&doSize, "doSize", "", "The size of the runner instances to provision.")
+	if other.LoadLevels != nil {
+		s.LoadLevels = other.LoadLevels
+	}
+	if other.StepSize.Duration > 0 {
+		s.StepSize = other.StepSize
+	}
+	if other.ClassSize > 0 {
+		s.ClassSize = other.ClassSize
+	}
+	if !other.ClassSizes.IsZero() {
+		s.ClassSizes = other.ClassSizes
+	}
+	if other.PreparedPortion.IsSet() {
+		s.PreparedPortion = other.PreparedPortion
+	}
+}
 
-	flag.BoolVar(&debug, "debug", false, "Enables additional debug logging.")
+// flagValues holds the values of all config flags of a single FlagSet.
+type flagValues struct {
+	Config
+	configFile   string
+	envName      string
+	scenarioName string
+}
 
-	flag.Parse()
+func registerFlags(fs *flag.FlagSet) *flagValues {
+	f := &flagValues{}
+
+	fs.StringVar(&f.configFile, "config", "", "Path to a json, yaml or toml config file.")
+	fs.StringVar(&f.envName, "env", "", "The name of the environment from the config file to use.")
+	fs.StringVar(&f.scenarioName, "scenario", "", "The name of the scenario from the config file to run.")
+
+	fs.StringVar(&f.Url, "url", "", "The URL to the system under test.")
+
+	fs.StringVar(&f.Reset, "reset", "", "How to reset the mongo instance, "+ResetFull+", "+ResetScoped+" to the test accounts or "+ResetNone+".")
+	fs.Var(&f.NoReset, "noReset", "Whether to skip the reset of the mongo instance, same as --reset "+ResetNone+".")
+	fs.StringVar(&f.DbUri, "dbUri", "", "The URI to the mongo instance.")
+	fs.StringVar(&f.Restore, "restore", "", "How to restore the dump, "+RestoreMongorestore+" or "+RestoreNative+".")
+	fs.Var(&f.Reconcile, "reconcile", "Whether to reconcile the submissions of the runners with the database after the run.")
+	fs.StringVar(&f.Dump, "dump", "", "Path to the mongodump archive or the directory of fixtures to restore.")
+	fs.StringVar(&f.Accounts, "accounts", "", "Where to read the test accounts from, a .json or .csv file, "+AccountsMongo+" for the database at dbUri or an http(s) URL.")
+	fs.StringVar(&f.AccountsDomain, "accountsDomain", "", "The email domain of the teachers whose classes are read from the database.")
+	fs.StringVar(&f.AccountsPassword, "accountsPassword", "", "The password of all accounts read from the database, or file:<path> or env:<name> to read it from.")
+	fs.StringVar(&f.AccountsToken, "accountsToken", "", "The bearer token to fetch the accounts with from a URL, or file:<path> or env:<name> to read it from.")
+
+	fs.Var(&f.LoadLevels, "loadLevels", "A comma-separated list of class concurrencies.")
+	fs.DurationVar(&f.StepSize.Duration, "stepSize", 0, "time between each step of the load curve.")
+	fs.IntVar(&f.ClassSize, "classSize", 0, "The number of pupils within a class.")
+	fs.Var(&f.ClassSizes, "classSizes", "The distribution of class sizes, either a range (15-32), a list (25,30) or a histogram (25:1,30:3).")
+	fs.Var(&f.PreparedPortion, "preparedPortion", "The portion of classes for which accounts should be created beforehand.")
+
+	fs.Var(&f.Local, "local", "If true, the tests will be run locally.")
+	fs.IntVar(&f.ClassesPerRunner, "classesPerRunner", 0, "The number of classes managed by a single runner instance.")
+	fs.IntVar(&f.ContainersPerRunner, "containersPerRunner", 0, "The number of runner containers the classes of a runner instance are spread across.")
+	fs.Var(&f.VuMemoryMB, "vuMemoryMB", "The estimated memory of a virtual user in MB, to pack classes onto runners by cost.")
+	fs.Var(&f.VuCpus, "vuCpus", "The estimated CPUs of a virtual user, to pack classes onto runners by cost.")
+	fs.Var(&f.UnpreparedFactor, "unpreparedFactor", "How much more an unprepared class costs than a prepared one.")
+	fs.StringVar(&f.DoApiKey, "doApiKey", "", "The API key for digital ocean, or file:<path> or env:<name> to read it from.")
+	fs.StringVar(&f.DdApiKey, "ddApiKey", "", "The API key for datadog, or file:<path> or env:<name> to read it from.")
+	fs.StringVar(&f.DoRegion, "doRegion", "", "The region to provision the runner instances in.")
+	fs.Var(&f.DoRegions, "doRegions", "A comma-separated list of regions with weights to spread the runner instances across, e.g. fra1:70,ams3:30.")
+	fs.StringVar(&f.DoSize, "doSize", "", "The size of the runner instances to provision.")
+	fs.StringVar(&f.DoImage, "doImage", "", "The snapshot ID or image slug to boot the runner instances from.")
+	fs.StringVar(&f.RunnerImage, "runnerImage", "", "The image of the runner container, pinned to its digest for the run.")
+	fs.StringVar(&f.AgentImage, "agentImage", "", "The image of the metrics agent container, pinned to its digest for the run.")
+	fs.Var(&f.MaxInstances, "maxInstances", "The maximum number of runner instances to provision.")
+	fs.Var(&f.MaxCost, "maxCost", "The maximum cost of all runner instances in USD.")
+
+	fs.Var(&f.Debug, "debug", "Enables additional debug logging.")
+
+	return f
 }
 
 func defaultConfig() *Config {
 	return &Config{
-		ClassesPerRunner: 1,
-		DoRegion:         "fra1",
-		DoSize:           "s-2vcpu-8gb",
+		Environment: Environment{
+			ClassesPerRunner:    1,
+			ContainersPerRunner: 1,
+			DoRegion:            "fra1",
+			DoSize:              "s-2vcpu-8gb",
+			RunnerImage:         runner.DefaultImages.Runner,
+			AgentImage:          runner.DefaultImages.Agent,
+		},
+		Scenario: Scenario{
+			Reset:    ResetFull,
+			Restore:  RestoreMongorestore,
+			Dump:     accounts.DefaultDumpFile,
+			Accounts: accounts.DefaultAccountsFile,
+		},
 	}
 }
 
-func parseConfigFile(path string) *Config {
-	if path == "" {
-		return &Config{}
+func firstNonEmpty(vals ...string) string {
+	for _, v := range vals {
+		if v != "" {
+			return v
+		}
 	}
+	return ""
+}
 
-	var c Config
-	b, err := ioutil.ReadFile(path)
-	if err != nil {
-		log.Fatalln("Couldn't read config file", err)
-	}
-	if err = json.Unmarshal(b, &c); err != nil {
-		log.Fatalln("Couldn't parse config file", err)
+// Capacity returns the configured capacity model of the runners.
+func (e *Environment) Capacity() controller.Capacity {
+	return controller.Capacity{
+		ClassesPerRunner:    e.ClassesPerRunner,
+		ContainersPerRunner: e.ContainersPerRunner,
+		Costs: controller.Costs{
+			VuMemoryMB:       e.VuMemoryMB.Value(),
+			VuCpus:           e.VuCpus.Value(),
+			UnpreparedFactor: e.UnpreparedFactor.Value(),
+		},
 	}
-
-	return &c
 }
 
-// TODO: implement rest
-func parseEnvVars() *Config {
-	c := &Config{}
-
-	if val, ok := os.LookupEnv("DB_URI"); ok {
-		c.DbUri = val
-	}
-	if val, ok := os.LookupEnv("DO_API_KEY"); ok {
-		c.DoApiKey = val
-	}
-	if val, ok := os.LookupEnv("DD_API_KEY"); ok {
-		c.DdApiKey = val
+// Regions returns the weighted regions to provision runner instances in.
+func (e *Environment) Regions() provisioner.Regions {
+	if len(e.DoRegions) > 0 {
+		return e.DoRegions
 	}
+	return provisioner.Regions{e.DoRegion: 1}
+}
 
-	if val, ok := os.LookupEnv("DEBUG"); ok {
-		parsed, err := strconv.ParseBool(val)
-		if err == nil {
-			c.Debug = parsed
-		}
+// Sizes returns the distribution of class sizes.
+func (s *Scenario) Sizes() accounts.SizeDistribution {
+	if !s.ClassSizes.IsZero() {
+		return s.ClassSizes
 	}
+	return accounts.FixedSize(s.ClassSize)
+}
 
-	return c
+// AccountSource returns the configured source of the test accounts.
+func (s *Scenario) AccountSource() accounts.AccountSource {
+	switch {
+	case s.Accounts == AccountsMongo:
+		return accounts.NewMongoSource(s.DbUri, s.AccountsDomain, s.AccountsPassword)
+	case s.isAccountsUrl():
+		return accounts.NewHTTPSource(s.Accounts, s.AccountsToken)
+	case s.isAccountsCsv():
+		return accounts.NewCSVSource(s.Accounts)
+	default:
+		return accounts.NewFileSource(s.Accounts)
+	}
 }
 
-func parseFlags() *Config {
-	c := &Config{
-		Url: url,
+// AccountsFile returns the path of the accounts file, if the accounts are
+// read from a JSON file.
+func (s *Scenario) AccountsFile() (string, bool) {
+	if s.Accounts == AccountsMongo || s.isAccountsUrl() || s.isAccountsCsv() {
+		return "", false
+	}
+	return s.Accounts, true
+}
 
-		NoReset:         noReset,
-		DbUri:           dbUri,
-		LoadLevels:      loadLevels,
-		StepSize:        controller.StepSize{Duration: stepSize},
-		ClassSize:       classSize,
-		PreparedPortion: preparedPortion,
+func (s *Scenario) isAccountsUrl() bool {
+	return strings.HasPrefix(s.Accounts, "http://") || strings.HasPrefix(s.Accounts, "https://")
+}
 
-		Local:            local,
-		ClassesPerRunner: classesPerRunner,
-		DoApiKey:         doApiKey,
-		DdApiKey:         ddApiKey,
-		DoRegion:         doRegion,
-		DoSize:           doSize,
+func (s *Scenario) isAccountsCsv() bool {
+	return strings.EqualFold(filepath.Ext(s.Accounts), ".csv")
+}
 
-		Debug: debug,
+// ResetMode returns how the database is reset, taking NoReset into account.
+func (s *Scenario) ResetMode() string {
+	if s.NoReset.Value() {
+		return ResetNone
 	}
-
-	return c
+	return s.Reset
 }
 
-func validate(c *Config) {
-
+// Images returns the configured runner images.
+func (e *Environment) Images() runner.Images {
+	return runner.Images{Runner: e.RunnerImage, Agent: e.AgentImage}
 }
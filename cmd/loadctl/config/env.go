@@ -0,0 +1,176 @@
+package config
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// envPrefix is prepended to the name of every env var read by loadctl.
+const envPrefix = "LOADCTL_"
+
+type envVar struct {
+	name string
+	// legacy is an unprefixed name which is still accepted as a fallback.
+	legacy string
+	set    func(c *Config, val string) error
+}
+
+var envVars = []envVar{
+	{name: "URL", set: func(c *Config, val string) error {
+		c.Url = val
+		return nil
+	}},
+
+	{name: "RESET", set: func(c *Config, val string) error {
+		c.Reset = val
+		return nil
+	}},
+	{name: "NO_RESET", set: func(c *Config, val string) error {
+		return c.NoReset.Set(val)
+	}},
+	{name: "DB_URI", legacy: "DB_URI", set: func(c *Config, val string) error {
+		c.DbUri = val
+		return nil
+	}},
+	{name: "RESTORE", set: func(c *Config, val string) error {
+		c.Restore = val
+		return nil
+	}},
+	{name: "DUMP", set: func(c *Config, val string) error {
+		c.Dump = val
+		return nil
+	}},
+	{name: "RECONCILE", set: func(c *Config, val string) error {
+		return c.Reconcile.Set(val)
+	}},
+	{name: "ACCOUNTS", set: func(c *Config, val string) error {
+		c.Accounts = val
+		return nil
+	}},
+	{name: "ACCOUNTS_DOMAIN", set: func(c *Config, val string) error {
+		c.AccountsDomain = val
+		return nil
+	}},
+	{name: "ACCOUNTS_PASSWORD", set: func(c *Config, val string) error {
+		c.AccountsPassword = val
+		return nil
+	}},
+	{name: "ACCOUNTS_TOKEN", set: func(c *Config, val string) error {
+		c.AccountsToken = val
+		return nil
+	}},
+
+	{name: "LOAD_LEVELS", set: func(c *Config, val string) error {
+		return c.LoadLevels.Set(val)
+	}},
+	{name: "STEP_SIZE", set: func(c *Config, val string) error {
+		d, err := time.ParseDuration(val)
+		c.StepSize.Duration = d
+		return err
+	}},
+	{name: "CLASS_SIZE", set: func(c *Config, val string) (err error) {
+		c.ClassSize, err = strconv.Atoi(val)
+		return err
+	}},
+	{name: "CLASS_SIZES", set: func(c *Config, val string) error {
+		return c.ClassSizes.Set(val)
+	}},
+	{name: "PREPARED_PORTION", set: func(c *Config, val string) error {
+		return c.PreparedPortion.Set(val)
+	}},
+
+	{name: "LOCAL", set: func(c *Config, val string) error {
+		return c.Local.Set(val)
+	}},
+	{name: "CLASSES_PER_RUNNER", set: func(c *Config, val string) (err error) {
+		c.ClassesPerRunner, err = strconv.Atoi(val)
+		return err
+	}},
+	{name: "CONTAINERS_PER_RUNNER", set: func(c *Config, val string) (err error) {
+		c.ContainersPerRunner, err = strconv.Atoi(val)
+		return err
+	}},
+	{name: "VU_MEMORY_MB", set: func(c *Config, val string) error {
+		return c.VuMemoryMB.Set(val)
+	}},
+	{name: "VU_CPUS", set: func(c *Config, val string) error {
+		return c.VuCpus.Set(val)
+	}},
+	{name: "UNPREPARED_FACTOR", set: func(c *Config, val string) error {
+		return c.UnpreparedFactor.Set(val)
+	}},
+	{name: "DO_API_KEY", legacy: "DO_API_KEY", set: func(c *Config, val string) error {
+		c.DoApiKey = val
+		return nil
+	}},
+	{name: "DD_API_KEY", legacy: "DD_API_KEY", set: func(c *Config, val string) error {
+		c.DdApiKey = val
+		return nil
+	}},
+	{name: "DO_REGION", set: func(c *Config, val string) error {
+		c.DoRegion = val
+		return nil
+	}},
+	{name: "DO_REGIONS", set: func(c *Config, val string) error {
+		return c.DoRegions.Set(val)
+	}},
+	{name: "DO_SIZE", set: func(c *Config, val string) error {
+		c.DoSize = val
+		return nil
+	}},
+	{name: "DO_IMAGE", set: func(c *Config, val string) error {
+		c.DoImage = val
+		return nil
+	}},
+	{name: "RUNNER_IMAGE", set: func(c *Config, val string) error {
+		c.RunnerImage = val
+		return nil
+	}},
+	{name: "AGENT_IMAGE", set: func(c *Config, val string) error {
+		c.AgentImage = val
+		return nil
+	}},
+
+	{name: "MAX_INSTANCES", set: func(c *Config, val string) error {
+		return c.MaxInstances.Set(val)
+	}},
+	{name: "MAX_COST", set: func(c *Config, val string) error {
+		return c.MaxCost.Set(val)
+	}},
+
+	{name: "DEBUG", legacy: "DEBUG", set: func(c *Config, val string) error {
+		return c.Debug.Set(val)
+	}},
+}
+
+// parseEnvVars reads every config parameter from its LOADCTL_ prefixed
+// env var. All malformed values are reported at once.
+func parseEnvVars() (*Config, error) {
+	c := &Config{}
+
+	var problems []string
+	for _, v := range envVars {
+		name := envPrefix + v.name
+		val, ok := os.LookupEnv(name)
+		if !ok && v.legacy != "" {
+			name = v.legacy
+			val, ok = os.LookupEnv(name)
+		}
+		if !ok {
+			continue
+		}
+
+		if err := v.set(c, val); err != nil {
+			problems = append(problems, fmt.Sprintf("%s: %v", name, err))
+		}
+	}
+
+	if len(problems) > 0 {
+		return nil, fmt.Errorf("malformed env vars:\n\t%s", strings.Join(problems, "\n\t"))
+	}
+
+	return c, nil
+}
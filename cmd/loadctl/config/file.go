@@ -0,0 +1,166 @@
+package config
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+
+	"github.com/BurntSushi/toml"
+	"gopkg.in/yaml.v2"
+)
+
+// File describes the layout of a config file. Parameters on the top level
+// apply to every run. Named environments and scenarios are merged on top
+// of them when selected via --env and --scenario.
+type File struct {
+	Config
+	Environments map[string]EnvironmentDef `json:"environments"`
+	Scenarios    map[string]ScenarioDef    `json:"scenarios"`
+}
+
+// EnvironmentDef is a named environment, optionally extending another one.
+type EnvironmentDef struct {
+	Extends string `json:"extends"`
+	Environment
+}
+
+// ScenarioDef is a named scenario, optionally extending another one.
+type ScenarioDef struct {
+	Extends string `json:"extends"`
+	Scenario
+}
+
+func (c *Config) mergeFile(path, envName, scenarioName string) error {
+	if path == "" {
+		if envName != "" || scenarioName != "" {
+			return fmt.Errorf("selecting an environment or scenario requires a config file")
+		}
+		return nil
+	}
+
+	f, err := readFile(path)
+	if err != nil {
+		return err
+	}
+	c.merge(&f.Config)
+
+	if envName != "" {
+		parents := make(map[string]string)
+		for name, def := range f.Environments {
+			parents[name] = def.Extends
+		}
+		chain, err := inheritanceChain(envName, parents)
+		if err != nil {
+			return fmt.Errorf("environment %w", err)
+		}
+		for _, name := range chain {
+			env := f.Environments[name].Environment
+			c.Environment.merge(&env)
+		}
+	}
+
+	if scenarioName != "" {
+		parents := make(map[string]string)
+		for name, def := range f.Scenarios {
+			parents[name] = def.Extends
+		}
+		chain, err := inheritanceChain(scenarioName, parents)
+		if err != nil {
+			return fmt.Errorf("scenario %w", err)
+		}
+		for _, name := range chain {
+			scenario := f.Scenarios[name].Scenario
+			c.Scenario.merge(&scenario)
+		}
+	}
+
+	return nil
+}
+
+// inheritanceChain returns the names of all ancestors of name, starting
+// with the root and ending with name itself.
+func inheritanceChain(name string, parents map[string]string) ([]string, error) {
+	var chain []string
+	seen := make(map[string]bool)
+	for name != "" {
+		parent, ok := parents[name]
+		if !ok {
+			return nil, fmt.Errorf("%q is not defined", name)
+		}
+		if seen[name] {
+			return nil, fmt.Errorf("%q extends itself", name)
+		}
+		seen[name] = true
+		chain = append([]string{name}, chain...)
+		name = parent
+	}
+
+	return chain, nil
+}
+
+// readFile parses a json, yaml or toml config file depending on its extension.
+func readFile(path string) (*File, error) {
+	var f File
+	if err := DecodeFile(path, &f); err != nil {
+		return nil, err
+	}
+
+	return &f, nil
+}
+
+// DecodeFile decodes a json, yaml or toml file into v depending on its
+// extension. Yaml and toml are converted to json first, so that the json
+// tags and unmarshalers of v apply to all formats alike. Unknown fields
+// are rejected.
+func DecodeFile(path string, v interface{}) error {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return err
+	}
+
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yaml", ".yml":
+		var y interface{}
+		if err := yaml.Unmarshal(b, &y); err != nil {
+			return err
+		}
+		if b, err = json.Marshal(stringKeys(y)); err != nil {
+			return err
+		}
+	case ".toml":
+		var t map[string]interface{}
+		if _, err := toml.Decode(string(b), &t); err != nil {
+			return err
+		}
+		if b, err = json.Marshal(t); err != nil {
+			return err
+		}
+	}
+
+	dec := json.NewDecoder(bytes.NewReader(b))
+	dec.DisallowUnknownFields()
+	return dec.Decode(v)
+}
+
+// stringKeys converts the map[interface{}]interface{} values produced by
+// yaml into map[string]interface{}, which can be marshalled as json.
+func stringKeys(v interface{}) interface{} {
+	switch v := v.(type) {
+	case map[interface{}]interface{}:
+		m := make(map[string]interface{}, len(v))
+		for key, val := range v {
+			m[fmt.Sprint(key)] = stringKeys(val)
+		}
+		return m
+	case []interface{}:
+		for i, val := range v {
+			v[i] = stringKeys(val)
+		}
+		return v
+	default:
+		return v
+	}
+}
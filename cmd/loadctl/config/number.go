@@ -0,0 +1,100 @@
+package config
+
+import (
+	"encoding/json"
+	"strconv"
+)
+
+// Int is an integer which remembers whether it has been set at all, like
+// Bool. It is used where zero is meaningful, so that a later config source
+// can overwrite an earlier value with zero.
+type Int struct {
+	set   bool
+	value int
+}
+
+func NewInt(value int) Int {
+	return Int{set: true, value: value}
+}
+
+// Value returns the integer value, defaulting to zero when unset.
+func (i Int) Value() int {
+	return i.value
+}
+
+func (i Int) IsSet() bool {
+	return i.set
+}
+
+// Set implements flag.Value.
+func (i *Int) Set(s string) error {
+	v, err := strconv.Atoi(s)
+	if err != nil {
+		return err
+	}
+	*i = NewInt(v)
+	return nil
+}
+
+func (i *Int) String() string {
+	return strconv.Itoa(i.value)
+}
+
+func (i Int) MarshalJSON() ([]byte, error) {
+	return json.Marshal(i.value)
+}
+
+func (i *Int) UnmarshalJSON(data []byte) error {
+	var v int
+	if err := json.Unmarshal(data, &v); err != nil {
+		return err
+	}
+	*i = NewInt(v)
+	return nil
+}
+
+// Float is a float which remembers whether it has been set at all, see Int.
+type Float struct {
+	set   bool
+	value float64
+}
+
+func NewFloat(value float64) Float {
+	return Float{set: true, value: value}
+}
+
+// Value returns the float value, defaulting to zero when unset.
+func (f Float) Value() float64 {
+	return f.value
+}
+
+func (f Float) IsSet() bool {
+	return f.set
+}
+
+// Set implements flag.Value.
+func (f *Float) Set(s string) error {
+	v, err := strconv.ParseFloat(s, 64)
+	if err != nil {
+		return err
+	}
+	*f = NewFloat(v)
+	return nil
+}
+
+func (f *Float) String() string {
+	return strconv.FormatFloat(f.value, 'g', -1, 64)
+}
+
+func (f Float) MarshalJSON() ([]byte, error) {
+	return json.Marshal(f.value)
+}
+
+func (f *Float) UnmarshalJSON(data []byte) error {
+	var v float64
+	if err := json.Unmarshal(data, &v); err != nil {
+		return err
+	}
+	*f = NewFloat(v)
+	return nil
+}
@@ -0,0 +1,55 @@
+package config
+
+import (
+	"encoding/json"
+	"io"
+	"net/url"
+
+	"github.com/DerGut/load-tests/secrets"
+	"gopkg.in/yaml.v2"
+)
+
+const mask = secrets.Mask
+
+// Masked returns a copy of the config with all secrets replaced.
+func (c *Config) Masked() *Config {
+	m := *c
+	if m.DoApiKey != "" {
+		m.DoApiKey = mask
+	}
+	if m.DdApiKey != "" {
+		m.DdApiKey = mask
+	}
+	if m.AccountsPassword != "" {
+		m.AccountsPassword = mask
+	}
+	if m.AccountsToken != "" {
+		m.AccountsToken = mask
+	}
+	if u, err := url.Parse(m.DbUri); err == nil {
+		m.DbUri = u.Redacted()
+	}
+
+	return &m
+}
+
+// Print writes the config as yaml with all secrets masked.
+func (c *Config) Print(w io.Writer) error {
+	b, err := json.Marshal(c.Masked())
+	if err != nil {
+		return err
+	}
+
+	// Yaml is a superset of json, MapSlice keeps the order of the fields
+	var ms yaml.MapSlice
+	if err := yaml.Unmarshal(b, &ms); err != nil {
+		return err
+	}
+
+	b, err = yaml.Marshal(ms)
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(b)
+	return err
+}
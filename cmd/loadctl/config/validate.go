@@ -0,0 +1,170 @@
+package config
+
+import (
+	"context"
+	"fmt"
+	neturl "net/url"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/DerGut/load-tests/accounts"
+)
+
+// ValidationError lists all problems found within a config.
+type ValidationError struct {
+	Problems []string
+}
+
+func (ve *ValidationError) Error() string {
+	return "invalid config:\n\t" + strings.Join(ve.Problems, "\n\t")
+}
+
+func (ve *ValidationError) add(format string, a ...interface{}) {
+	ve.Problems = append(ve.Problems, fmt.Sprintf(format, a...))
+}
+
+// Validate checks the config for problems which would otherwise only
+// surface once the run has started. It reports all of them at once.
+func (c *Config) Validate() error {
+	ve := &ValidationError{}
+
+	if c.Url == "" {
+		ve.add("url is required")
+	} else if u, err := neturl.Parse(c.Url); err != nil {
+		ve.add("url %q can't be parsed: %v", c.Url, err)
+	} else if (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		ve.add("url %q should be an absolute http(s) URL", c.Url)
+	}
+
+	switch c.ResetMode() {
+	case ResetFull, ResetScoped:
+		if c.DbUri == "" {
+			ve.add("dbUri is required unless reset is %s", ResetNone)
+		}
+	case ResetNone:
+	default:
+		ve.add("reset should be %s, %s or %s, got %q", ResetFull, ResetScoped, ResetNone, c.Reset)
+	}
+	validateRestore(ve, &c.Scenario)
+	if c.Reconcile.Value() && c.DbUri == "" {
+		ve.add("dbUri is required to reconcile")
+	}
+	sourceValid := true
+	if c.Accounts == AccountsMongo {
+		if c.DbUri == "" {
+			ve.add("dbUri is required to read the accounts from %s", AccountsMongo)
+			sourceValid = false
+		}
+		if c.AccountsDomain == "" {
+			ve.add("accountsDomain is required to read the accounts from %s", AccountsMongo)
+			sourceValid = false
+		}
+		if c.AccountsPassword == "" {
+			ve.add("accountsPassword is required to read the accounts from %s", AccountsMongo)
+			sourceValid = false
+		}
+		// Resets would delete or overwrite the accounts read from the very same database
+		if c.ResetMode() != ResetNone {
+			ve.add("accounts from %s require reset %s", AccountsMongo, ResetNone)
+		}
+	}
+
+	levelsValid := validateLoadLevels(ve, c)
+	if c.StepSize.Duration <= 0 {
+		ve.add("stepSize should be positive")
+	}
+	sizesValid := true
+	if !c.ClassSizes.IsZero() {
+		if err := c.ClassSizes.Validate(); err != nil {
+			ve.add("classSizes: %v", err)
+			sizesValid = false
+		}
+	} else if c.ClassSize <= 0 {
+		ve.add("classSize should be positive")
+		sizesValid = false
+	}
+	portion := c.PreparedPortion.Value()
+	portionValid := portion >= 0 && portion <= 1
+	if !portionValid {
+		ve.add("preparedPortion should be within [0, 1], got %v", portion)
+	}
+	if sourceValid && levelsValid && portionValid && sizesValid {
+		ctx, cancel := context.WithTimeout(context.Background(), 1*time.Minute)
+		defer cancel()
+		if _, err := accounts.Get(ctx, c.AccountSource(), c.LoadLevels.Total(), c.Sizes(), portion); err != nil {
+			ve.add("accounts are insufficient for %d classes of size %s: %v", c.LoadLevels.Total(), c.Sizes(), err)
+		}
+	}
+
+	if c.ClassesPerRunner <= 0 {
+		ve.add("classesPerRunner should be positive")
+	}
+	if c.ContainersPerRunner <= 0 {
+		ve.add("containersPerRunner should be positive")
+	}
+	costs := c.VuMemoryMB.Value() > 0 || c.VuCpus.Value() > 0
+	if !costs && c.ContainersPerRunner > c.ClassesPerRunner && c.ClassesPerRunner > 0 {
+		ve.add("containersPerRunner (%d) should not exceed classesPerRunner (%d)", c.ContainersPerRunner, c.ClassesPerRunner)
+	}
+	if c.VuMemoryMB.Value() < 0 || c.VuCpus.Value() < 0 || c.UnpreparedFactor.Value() < 0 {
+		ve.add("vuMemoryMB, vuCpus and unpreparedFactor should not be negative")
+	}
+	if !c.Local.Value() {
+		if c.DoApiKey == "" {
+			ve.add("doApiKey is required for remote runs")
+		}
+		if c.DoRegion == "" && len(c.DoRegions) == 0 {
+			ve.add("doRegion or doRegions is required for remote runs")
+		}
+		for _, r := range c.DoRegions.Slugs() {
+			if c.DoRegions[r] <= 0 {
+				ve.add("doRegions weight of %s should be positive, got %v", r, c.DoRegions[r])
+			}
+		}
+		if c.DoSize == "" {
+			ve.add("doSize is required for remote runs")
+		}
+	}
+	if c.MaxInstances.Value() < 0 {
+		ve.add("maxInstances should not be negative")
+	}
+	if c.MaxCost.Value() < 0 {
+		ve.add("maxCost should not be negative")
+	}
+
+	if len(ve.Problems) > 0 {
+		return ve
+	}
+
+	return nil
+}
+
+func validateLoadLevels(ve *ValidationError, c *Config) bool {
+	if len(c.LoadLevels) == 0 {
+		ve.add("loadLevels should have at least one value")
+		return false
+	}
+
+	valid := true
+	for i, l := range c.LoadLevels {
+		if l < 0 {
+			ve.add("loadLevels[%d] should not be negative, got %d", i, l)
+			valid = false
+		}
+	}
+
+	return valid
+}
+
+func validateRestore(ve *ValidationError, s *Scenario) {
+	switch s.Restore {
+	case RestoreNative:
+	case RestoreMongorestore:
+		if info, err := os.Stat(s.Dump); err == nil && info.IsDir() {
+			ve.add("dump %s is a directory of fixtures, which only restore %s supports", s.Dump, RestoreNative)
+		}
+	default:
+		ve.add("restore should be %s or %s, got %q", RestoreMongorestore, RestoreNative, s.Restore)
+	}
+}
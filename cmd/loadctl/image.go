@@ -0,0 +1,195 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/DerGut/load-tests/cmd/loadctl/config"
+	"github.com/DerGut/load-tests/controller/provisioner"
+	"github.com/DerGut/load-tests/controller/runner"
+)
+
+const (
+	// imagePrefix is the name prefix of all snapshots built by loadctl.
+	imagePrefix = "load-tests-runner-"
+	// imageFile records the latest image built by loadctl.
+	imageFile = ".loadctl/image.json"
+)
+
+func buildImage(fs *flag.FlagSet, args []string) error {
+	conf, err := config.Parse(fs, args)
+	if err != nil {
+		return err
+	}
+	if conf.DoApiKey == "" {
+		return fmt.Errorf("building an image requires a doApiKey")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 1*time.Hour)
+	defer cancel()
+	handleSignal(cancel)
+
+	im := newImager(conf)
+	name := fmt.Sprintf("%s%d", imagePrefix, time.Now().Unix())
+
+	log.Println("Provisioning instance for", name)
+	inst, err := im.Provision(ctx, "image-"+generateID(), "")
+	if err != nil {
+		return err
+	}
+	defer inst.Destroy()
+
+	log.Println("Pulling images on", inst)
+	if err := inst.RunCmd(ctx, runner.PullCmd(conf.Images())); err != nil {
+		return fmt.Errorf("failed to pull images: %w", err)
+	}
+
+	img, err := im.Snapshot(ctx, inst, name)
+	if err != nil {
+		return err
+	}
+
+	// Runs spread across several regions need the image in each of them
+	for _, r := range conf.Regions().Slugs()[1:] {
+		if err := im.Transfer(ctx, img.ID, r); err != nil {
+			return err
+		}
+		img.Regions = append(img.Regions, r)
+	}
+
+	if err := saveImage(img); err != nil {
+		return err
+	}
+	log.Println("Built image", img.Name, "with ID", img.ID)
+
+	return nil
+}
+
+func listImages(fs *flag.FlagSet, args []string) error {
+	conf, err := config.Parse(fs, args)
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 1*time.Minute)
+	defer cancel()
+
+	images, err := newImager(conf).Images(ctx, imagePrefix)
+	if err != nil {
+		return err
+	}
+	current := recordedImage()
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "ID\tNAME\tREGIONS\tSIZE\tAGE\tCURRENT")
+	for _, img := range images {
+		age := time.Since(img.Created).Round(time.Second)
+		cur := ""
+		if img.ID == current.ID {
+			cur = "*"
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%.1fGB\t%s\t%s\n", img.ID, img.Name, strings.Join(img.Regions, ","), img.SizeGB, age, cur)
+	}
+
+	return w.Flush()
+}
+
+func pruneImages(fs *flag.FlagSet, args []string) error {
+	keep := fs.Int("keep", 2, "The number of most recent images to keep.")
+	dryRun := fs.Bool("dryRun", false, "Only print the images that would be deleted.")
+	conf, err := config.Parse(fs, args)
+	if err != nil {
+		return err
+	}
+	if *keep < 0 {
+		return fmt.Errorf("keep should not be negative: %w", errUsage)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Minute)
+	defer cancel()
+
+	im := newImager(conf)
+	images, err := im.Images(ctx, imagePrefix)
+	if err != nil {
+		return err
+	}
+	if len(images) <= *keep {
+		return nil
+	}
+
+	current := recordedImage()
+	for _, img := range images[:len(images)-*keep] {
+		if img.ID == current.ID {
+			continue
+		}
+		log.Println("Deleting image", img.Name)
+		if *dryRun {
+			continue
+		}
+		if err := im.DeleteImage(ctx, img.ID); err != nil {
+			return fmt.Errorf("failed to delete image %s: %w", img.Name, err)
+		}
+	}
+
+	return nil
+}
+
+// newImager returns an Imager which builds images in the first of the configured regions.
+func newImager(conf *config.Config) provisioner.Imager {
+	return provisioner.NewDOImager(conf.DoApiKey, conf.Regions().Slugs()[0], conf.DoSize, conf.Debug.Value())
+}
+
+// dropletImage returns the configured image or the one recorded by
+// `image build` if it is available in all regions.
+func dropletImage(conf *config.Config) string {
+	if conf.DoImage != "" {
+		return conf.DoImage
+	}
+
+	img := recordedImage()
+	available := make(map[string]bool, len(img.Regions))
+	for _, r := range img.Regions {
+		available[r] = true
+	}
+	for _, r := range conf.Regions().Slugs() {
+		if !available[r] {
+			return ""
+		}
+	}
+
+	return img.ID
+}
+
+func saveImage(img provisioner.Image) error {
+	b, err := json.MarshalIndent(img, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(imageFile), 0o755); err != nil {
+		return err
+	}
+	return ioutil.WriteFile(imageFile, b, 0o644)
+}
+
+// recordedImage returns the image recorded by the last `image build`, or
+// the zero Image if there is none.
+func recordedImage() provisioner.Image {
+	var img provisioner.Image
+	b, err := ioutil.ReadFile(imageFile)
+	if err != nil {
+		return img
+	}
+	if err := json.Unmarshal(b, &img); err != nil {
+		log.Println("Ignoring invalid image record:", err)
+	}
+	return img
+}
@@ -3,96 +3,97 @@ package main
 import (
 	"context"
 	"errors"
+	"flag"
 	"log"
 	"math/rand"
 	"os"
 	"os/signal"
 	"time"
 
-	"github.com/DerGut/load-tests/accounts"
 	"github.com/DerGut/load-tests/cmd/loadctl/config"
-	"github.com/DerGut/load-tests/controller"
-	"github.com/DerGut/load-tests/controller/provisioner"
+	"github.com/DerGut/load-tests/secrets"
 )
 
 func init() {
 	rand.Seed(time.Now().UnixNano())
 }
 
-func main() {
-	conf := config.Parse()
-
-	accs := setupAccounts(conf)
-
-	// Shuffle in order to use prepared and unprepared classes evenly throughout the test run
-	shuffle(accs)
-	runCfg := parseRunConfig(conf, accs)
-
-	p := provisioner.NewDO(conf.DoApiKey, conf.DoRegion, conf.DoSize, conf.Debug)
+var root = &command{
+	name: "loadctl",
+	subcommands: []*command{
+		{name: "run", description: "Run a load test.", run: run},
+		{name: "plan", description: "Show what a run would do and cost, without provisioning anything.", run: plan},
+		{
+			name:        "accounts",
+			description: "Manage the test accounts.",
+			subcommands: []*command{
+				{name: "generate", description: "Generate a new accounts file for all classes of the load levels, the class size and prepared portion.", run: generateAccounts},
+				{name: "seed", description: "Seed a scratch MongoDB with the accounts file and dump it for restoring.", run: seedAccounts},
+				{name: "restore", description: "Reset the database of the system under test with the dump, fully or scoped to the test accounts.", run: restoreAccounts},
+				{name: "verify", description: "Verify that the accounts suffice for the configured scenario and match the dump or database.", run: verifyAccounts},
+				{name: "leases", description: "List the accounts leased by running runs.", run: listLeases},
+			},
+		},
+		{
+			name:        "runners",
+			description: "Manage remote runner instances.",
+			subcommands: []*command{
+				{name: "list", description: "List all runner instances.", run: listRunners},
+				{name: "logs", args: "<runner>", description: "Print the logs of a runner.", run: runnerLogs},
+				{name: "stop", args: "<runner|run>...", description: "Stop and destroy runners by their name or run ID.", run: stopRunners},
+			},
+		},
+		{
+			name:        "image",
+			description: "Manage the snapshot image runner instances boot from.",
+			subcommands: []*command{
+				{name: "build", description: "Build a snapshot with all runner images pulled and use it for later runs.", run: buildImage},
+				{name: "list", description: "List all snapshot images.", run: listImages},
+				{name: "prune", description: "Delete all but the most recent snapshot images.", run: pruneImages},
+			},
+		},
+		{name: "gc", description: "Destroy runner instances left behind by finished or crashed runs.", run: gc},
+		{name: "report", args: "[run]", description: "Report on a run, the latest one by default.", run: report},
+		{
+			name:        "config",
+			description: "Inspect the configuration.",
+			subcommands: []*command{
+				{name: "print", description: "Print the effective configuration with secrets masked.", run: printConfig},
+			},
+		},
+	},
+}
 
-	runID := generateID()
+func main() {
+	log.SetOutput(secrets.Writer(os.Stderr))
 
-	var c controller.Controller
-	if conf.Local {
-		c = controller.NewLocal()
-	} else {
-		c = controller.NewRemote(runID, conf.ClassesPerRunner, p, conf.DdApiKey)
+	err := root.execute(nil, os.Args[1:])
+	if errors.Is(err, context.Canceled) {
+		os.Exit(0)
 	}
-
-	// TODO: test duration should not start before first runner has been deployed
-	// also +1 step should not be necessary, we should stop after exactly n steps
-	// graceful shutdown could be handled on top of this.
-	// perhaps contexts aren't the right tool for this?
-	// good enough for BA anyway?
-	// Wait one step size longer for graceful shutdown
-	timeout := time.Duration(len(conf.LoadLevels)+1) * conf.StepSize.Duration
-	ctx, cancel := context.WithTimeout(context.Background(), timeout)
-	defer cancel()
-	handleSignal(cancel)
-
-	log.Println("Starting controller")
-	if err := c.Run(ctx, runCfg); err != nil {
-		if errors.Is(err, context.Canceled) {
-			os.Exit(0)
-		}
-		if errors.Is(err, context.DeadlineExceeded) {
-			log.Println("Exceeded deadline")
-			os.Exit(1)
-		}
-		log.Fatalln("failed running:", err)
+	if errors.Is(err, context.DeadlineExceeded) {
+		log.Println("Exceeded deadline")
+		os.Exit(1)
+	}
+	if err != nil {
+		log.Fatalln(err)
 	}
 }
 
-func setupAccounts(conf *config.Config) []accounts.Classroom {
-	maxConcurrency := maxConcurrency(conf.LoadLevels)
-
-	accs, err := accounts.Get(maxConcurrency, conf.ClassSize, conf.PreparedPortion)
+// printConfig prints the effective configuration after merging all sources.
+func printConfig(fs *flag.FlagSet, args []string) error {
+	conf, err := config.Parse(fs, args)
 	if err != nil {
-		log.Fatalln("Couldn't get accounts:", err)
+		return err
 	}
-
-	if !conf.NoReset {
-		restoreDump(conf.DbUri, conf.Debug)
+	if err := conf.Print(os.Stdout); err != nil {
+		return err
 	}
-
-	return accs
-}
-
-func shuffle(accs []accounts.Classroom) {
-	rand.Shuffle(len(accs), func(i, j int) {
-		tmp := accs[i]
-		accs[i] = accs[j]
-		accs[j] = tmp
-	})
-}
-
-func parseRunConfig(conf *config.Config, accounts []accounts.Classroom) controller.RunConfig {
-	lc := controller.LoadCurve{LoadLevels: conf.LoadLevels, StepSize: conf.StepSize}
-	return controller.RunConfig{
-		Url:       conf.Url,
-		LoadCurve: &lc,
-		Accounts:  accounts,
+	if err := conf.Validate(); err != nil {
+		log.Println(err)
 	}
+
+	return nil
 }
 
 const (
@@ -118,23 +119,3 @@ func handleSignal(cancel context.CancelFunc) {
 		cancel()
 	}()
 }
-
-func maxConcurrency(levels controller.LoadLevels) int {
-	max := 0
-	for _, l := range levels {
-		if l > max {
-			max = l
-		}
-	}
-
-	return max
-}
-
-func restoreDump(dbUri string, debug bool) {
-	log.Println("Resetting MongoDB instance with dumped data")
-	ctx, cancel := context.WithTimeout(context.Background(), 1*time.Minute)
-	defer cancel()
-	if err := accounts.Restore(ctx, dbUri, accounts.DefaultDumpFile, debug); err != nil {
-		log.Fatalln("Failed to restore dump:", err)
-	}
-}
@@ -0,0 +1,105 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/DerGut/load-tests/accounts"
+	"github.com/DerGut/load-tests/controller"
+)
+
+// plan prints what a run with the current config would do, without
+// resetting the database or provisioning any instances.
+func plan(fs *flag.FlagSet, args []string) error {
+	conf, err := parseValid(fs, args)
+	if err != nil {
+		return err
+	}
+
+	accs, err := getAccounts(conf)
+	if err != nil {
+		return err
+	}
+	runCfg := parseRunConfig(conf, accs)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	c, p := newController(conf, "plan", conf.Images())
+	pl, err := c.Plan(ctx, runCfg)
+	if err != nil {
+		return err
+	}
+
+	printPlan(os.Stdout, pl)
+
+	if !pl.Remote {
+		fmt.Println("Runners are started locally, no instances will be provisioned.")
+		return nil
+	}
+
+	regions := conf.Regions()
+	spread := regions.Spread(pl.Runners())
+	perRegion := make([]string, 0, len(regions))
+	for _, r := range regions.Slugs() {
+		perRegion = append(perRegion, fmt.Sprintf("%d in %s", spread[r], r))
+	}
+	fmt.Printf("Instances: %d droplet(s) of size %s, %s\n", pl.Runners(), conf.DoSize, strings.Join(perRegion, ", "))
+	if conf.MaxInstances.Value() > 0 && pl.Runners() > conf.MaxInstances.Value() {
+		fmt.Printf("WARNING: exceeds the maximum of %d instances, the run would be aborted\n", conf.MaxInstances.Value())
+	}
+
+	size, err := p.Size(ctx)
+	if err != nil {
+		log.Println("Couldn't estimate cost, failed to look up droplet size:", err)
+		return nil
+	}
+	hours := pl.InstanceHours()
+	cost := hours * size.PriceHourly
+	fmt.Printf("Estimated cost: %.0f instance-hour(s) at $%.5f/h = $%.2f\n", hours, size.PriceHourly, cost)
+	if conf.MaxCost.Value() > 0 && cost > conf.MaxCost.Value() {
+		fmt.Printf("WARNING: exceeds the maximum cost of $%.2f, the run would be aborted\n", conf.MaxCost.Value())
+	}
+	return nil
+}
+
+func printPlan(w io.Writer, p *controller.Plan) {
+	fmt.Fprintln(w, "Classes are shuffled at the start of a run, the actual assignment to runners will differ.")
+	fmt.Fprintln(w)
+
+	for i, s := range p.Steps {
+		classes := len(s.Extended)
+		for _, r := range s.Runners {
+			classes += len(r)
+		}
+		fmt.Fprintf(w, "Step %d at %s: %d running classes (+%d, -%d), %d new runner(s)\n", i+1, s.Start, s.Load, classes, len(s.Removed), len(s.Runners))
+		if len(s.Removed) > 0 {
+			fmt.Fprintf(w, "\tremoved: %s\n", teachers(s.Removed))
+		}
+		if len(s.Extended) > 0 {
+			fmt.Fprintf(w, "\trunning runners: %s\n", teachers(s.Extended))
+		}
+		for j, r := range s.Runners {
+			fmt.Fprintf(w, "\trunner %d: %s\n", j+1, teachers(r))
+		}
+	}
+	fmt.Fprintf(w, "Shutdown at %s\n", p.Shutdown)
+	fmt.Fprintln(w)
+
+	fmt.Fprintf(w, "Runtime: %s\n", p.Runtime)
+	fmt.Fprintf(w, "Peak load: %d classes, %d VUs in as many browsers\n", p.PeakClasses(), p.PeakVUs())
+}
+
+func teachers(classes []accounts.Classroom) string {
+	emails := make([]string, len(classes))
+	for i, c := range classes {
+		emails[i] = c.Teacher.Email
+	}
+	return strings.Join(emails, ", ")
+}
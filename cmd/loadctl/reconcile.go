@@ -0,0 +1,69 @@
+package main
+
+import (
+	"context"
+	"log"
+	"sort"
+	"time"
+
+	"github.com/DerGut/load-tests/accounts"
+	"github.com/DerGut/load-tests/cmd/loadctl/config"
+	"github.com/DerGut/load-tests/journal"
+)
+
+// reconcile compares what the runners reported the pupils of the classes
+// submitted with the database and records the result to the journal.
+func reconcile(conf *config.Config, j *journal.Run, classes []accounts.Classroom) {
+	log.Println("Reconciling submissions with", accounts.Database(conf.DbUri))
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Minute)
+	defer cancel()
+
+	produced, err := accounts.QueryProduced(ctx, conf.DbUri, classes)
+	if err != nil {
+		log.Println("Failed to reconcile submissions:", err)
+		j.SetReconciliation(journal.Reconciliation{Error: err.Error()})
+		return
+	}
+
+	rec := reconcileSubmissions(j.Submitted, produced)
+	if len(rec.LostWrites) > 0 {
+		log.Println("WARNING:", len(rec.LostWrites), "pupil(s) have fewer submissions in the database than reported, see loadctl report")
+	}
+	j.SetReconciliation(rec)
+}
+
+// reconcileSubmissions flags the pupils of whom the database holds fewer
+// submissions than reported. The database may hold more, since runners are
+// queried before they are stopped.
+func reconcileSubmissions(reported map[string]journal.Submissions, produced *accounts.Produced) journal.Reconciliation {
+	stored := make(map[string]journal.Submissions)
+	for pupil, n := range produced.Exercises {
+		s := stored[pupil]
+		s.Exercises = n
+		stored[pupil] = s
+	}
+	for pupil, n := range produced.TaskSeries {
+		s := stored[pupil]
+		s.TaskSeries = n
+		stored[pupil] = s
+	}
+
+	rec := journal.Reconciliation{ClassLogs: produced.ClassLogs}
+	for _, s := range stored {
+		rec.Stored = rec.Stored.Add(s)
+	}
+	pupils := make([]string, 0, len(reported))
+	for pupil, r := range reported {
+		rec.Reported = rec.Reported.Add(r)
+		pupils = append(pupils, pupil)
+	}
+	sort.Strings(pupils)
+
+	for _, pupil := range pupils {
+		r, s := reported[pupil], stored[pupil]
+		if s.Exercises < r.Exercises || s.TaskSeries < r.TaskSeries {
+			rec.LostWrites = append(rec.LostWrites, journal.LostWrite{Pupil: pupil, Reported: r, Stored: s})
+		}
+	}
+	return rec
+}
@@ -0,0 +1,111 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"time"
+
+	"github.com/DerGut/load-tests/journal"
+)
+
+func report(fs *flag.FlagSet, args []string) error {
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	var r *journal.Run
+	var err error
+	switch fs.NArg() {
+	case 0:
+		r, err = journal.Latest(journal.DefaultDir)
+	case 1:
+		r, err = journal.Load(journal.DefaultDir, fs.Arg(0))
+	default:
+		return errUsage
+	}
+	if err != nil {
+		return err
+	}
+
+	printReport(os.Stdout, r)
+	return nil
+}
+
+func printReport(w io.Writer, r *journal.Run) {
+	fmt.Fprintf(w, "Run %s\n", r.ID)
+	fmt.Fprintf(w, "Started:  %s\n", r.Start.Format(time.RFC1123))
+	if r.Finished() {
+		fmt.Fprintf(w, "Finished: %s (after %s)\n", r.End.Format(time.RFC1123), r.End.Sub(r.Start).Round(time.Second))
+	} else {
+		fmt.Fprintln(w, "Finished: not yet, or loadctl crashed")
+	}
+	if r.Error != "" {
+		fmt.Fprintf(w, "Error:    %s\n", r.Error)
+	}
+	for _, name := range sortedKeys(r.Images) {
+		fmt.Fprintf(w, "Image:    %s %s\n", name, r.Images[name])
+	}
+	fmt.Fprintln(w)
+
+	runners := 0
+	perRegion := make(map[string]int)
+	for i, s := range r.Steps {
+		fmt.Fprintf(w, "Step %d at %s: %d running classes, %d new runner(s)\n", i+1, s.Start.Sub(r.Start).Round(time.Second), s.Load, len(s.Runners))
+		for _, name := range s.Runners {
+			if region, ok := r.Regions[name]; ok {
+				fmt.Fprintf(w, "\t%s in %s\n", name, region)
+				perRegion[region]++
+			} else {
+				fmt.Fprintf(w, "\t%s\n", name)
+			}
+		}
+		runners += len(s.Runners)
+	}
+	fmt.Fprintf(w, "\n%d runner(s) in total\n", runners)
+	regions := make([]string, 0, len(perRegion))
+	for region := range perRegion {
+		regions = append(regions, region)
+	}
+	sort.Strings(regions)
+	for _, region := range regions {
+		fmt.Fprintf(w, "\t%d in %s\n", perRegion[region], region)
+	}
+	if r.ProjectedSpend > 0 || r.ActualSpend > 0 {
+		fmt.Fprintf(w, "Projected spend: $%.2f, actual spend: $%.2f\n", r.ProjectedSpend, r.ActualSpend)
+	}
+	if rec := r.Reconciliation; rec != nil {
+		printReconciliation(w, rec)
+	}
+	fmt.Fprintf(w, "\nConfig: %s\n", r.Config)
+}
+
+func printReconciliation(w io.Writer, rec *journal.Reconciliation) {
+	fmt.Fprintln(w)
+	if rec.Error != "" {
+		fmt.Fprintf(w, "Reconciliation failed: %s\n", rec.Error)
+		return
+	}
+	fmt.Fprintf(w, "Submitted exercises:   %d reported, %d in the database\n", rec.Reported.Exercises, rec.Stored.Exercises)
+	fmt.Fprintf(w, "Submitted task series: %d reported, %d in the database\n", rec.Reported.TaskSeries, rec.Stored.TaskSeries)
+	fmt.Fprintf(w, "Class log entries:     %d\n", rec.ClassLogs)
+	if len(rec.LostWrites) == 0 {
+		fmt.Fprintln(w, "No lost writes")
+		return
+	}
+	fmt.Fprintf(w, "LOST WRITES of %d pupil(s):\n", len(rec.LostWrites))
+	for _, l := range rec.LostWrites {
+		fmt.Fprintf(w, "\t%s: %d of %d exercises, %d of %d task series\n", l.Pupil, l.Stored.Exercises, l.Reported.Exercises, l.Stored.TaskSeries, l.Reported.TaskSeries)
+	}
+}
+
+func sortedKeys(m map[string]string) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,302 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"math/rand"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/DerGut/load-tests/accounts"
+	"github.com/DerGut/load-tests/cmd/loadctl/config"
+	"github.com/DerGut/load-tests/controller"
+	"github.com/DerGut/load-tests/controller/provisioner"
+	"github.com/DerGut/load-tests/controller/runner"
+	"github.com/DerGut/load-tests/journal"
+)
+
+func run(fs *flag.FlagSet, args []string) error {
+	conf, err := parseValid(fs, args)
+	if err != nil {
+		return err
+	}
+
+	runID := generateID()
+	runCfg := parseRunConfig(conf, nil)
+	pool, accs, err := setupAccounts(conf, runID, runCfg.LoadCurve.Duration())
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err := pool.Close(); err != nil {
+			log.Println("Failed to release accounts:", err)
+		}
+	}()
+
+	// Shuffle in order to use prepared and unprepared classes evenly throughout the test run
+	shuffle(accs)
+	runCfg.Accounts = accs
+	runCfg.Pool = pool
+
+	images, err := resolveImages(conf)
+	if err != nil {
+		return err
+	}
+
+	c, _ := newController(conf, runID, images)
+
+	runCfg.Journal, err = journal.New(journal.DefaultDir, runID, conf.Masked())
+	if err != nil {
+		return fmt.Errorf("failed to create journal: %w", err)
+	}
+	log.Println("Recording run", runID, "to", journal.DefaultDir)
+	if !conf.Local.Value() {
+		runCfg.Journal.SetImages(map[string]string{"runner": images.Runner, "agent": images.Agent})
+	}
+
+	// TODO: test duration should not start before first runner has been deployed
+	// also +1 step should not be necessary, we should stop after exactly n steps
+	// graceful shutdown could be handled on top of this.
+	// perhaps contexts aren't the right tool for this?
+	// good enough for BA anyway?
+	// Wait one step size longer for graceful shutdown
+	timeout := runCfg.LoadCurve.Duration()
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	handleSignal(cancel)
+
+	log.Println("Starting controller")
+	err = c.Run(ctx, runCfg)
+	if conf.Reconcile.Value() {
+		reconcile(conf, runCfg.Journal, accs)
+	}
+	if jErr := runCfg.Journal.Finish(err); jErr != nil {
+		log.Println("Failed to save journal:", jErr)
+	}
+	if err != nil {
+		return fmt.Errorf("failed running: %w", err)
+	}
+
+	return nil
+}
+
+// parseValid parses the config and validates it.
+func parseValid(fs *flag.FlagSet, args []string) (*config.Config, error) {
+	conf, err := config.Parse(fs, args)
+	if err != nil {
+		return nil, err
+	}
+	if err := conf.Validate(); err != nil {
+		return nil, err
+	}
+
+	return conf, nil
+}
+
+// newController returns the controller for the config together with the
+// provisioner used for remote runners, which is nil for local runs.
+func newController(conf *config.Config, runID string, images runner.Images) (controller.Controller, provisioner.Provisioner) {
+	if conf.Local.Value() {
+		return controller.NewLocal(), nil
+	}
+
+	p := newProvisioner(conf)
+	budget := controller.Budget{MaxInstances: conf.MaxInstances.Value(), MaxCost: conf.MaxCost.Value()}
+	return controller.NewRemote(runID, conf.Capacity(), p, conf.DdApiKey, images, budget), p
+}
+
+// resolveImages pins the configured images of remote runners to their digest.
+func resolveImages(conf *config.Config) (runner.Images, error) {
+	if conf.Local.Value() {
+		return conf.Images(), nil
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 1*time.Minute)
+	defer cancel()
+
+	images, err := conf.Images().Resolve(ctx)
+	if err != nil {
+		return runner.Images{}, err
+	}
+	log.Println("Using runner image", images.Runner, "and agent image", images.Agent)
+
+	return images, nil
+}
+
+func newProvisioner(conf *config.Config) provisioner.Provisioner {
+	return provisioner.NewDO(conf.DoApiKey, conf.Regions(), conf.DoSize, dropletImage(conf), conf.Debug.Value())
+}
+
+const (
+	// leaseFile records the accounts leased by all runs on this machine.
+	leaseFile = ".loadctl/leases.json"
+	// leaseMargin covers provisioning and tearing down runners beyond the
+	// duration of the load curve.
+	leaseMargin = 1 * time.Hour
+)
+
+// setupAccounts leases the accounts of the run and resets the database.
+func setupAccounts(conf *config.Config, runID string, duration time.Duration) (*accounts.Pool, []accounts.Classroom, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 1*time.Minute)
+	defer cancel()
+	pool, err := accounts.NewPool(ctx, conf.AccountSource(), leaseFile, accounts.Database(conf.DbUri), runID, duration+leaseMargin)
+	if err != nil {
+		return nil, nil, fmt.Errorf("couldn't read accounts: %w", err)
+	}
+
+	var accs []accounts.Classroom
+	for _, d := range conf.Sizes().Demands(conf.LoadLevels.Total(), conf.PreparedPortion.Value()) {
+		prepared, err := pool.Lease(accounts.Criteria{Prepared: true, ClassSize: d.Size}, d.Prepared)
+		if err == nil {
+			var unprepared []accounts.Classroom
+			unprepared, err = pool.Lease(accounts.Criteria{Prepared: false, ClassSize: d.Size}, d.Unprepared)
+			accs = append(accs, prepared...)
+			accs = append(accs, unprepared...)
+		}
+		if err != nil {
+			pool.Close()
+			return nil, nil, fmt.Errorf("couldn't lease accounts: %w", err)
+		}
+	}
+
+	if conf.ResetMode() != config.ResetNone {
+		if err := resetDatabase(conf, runID, accs); err != nil {
+			pool.Close()
+			return nil, nil, err
+		}
+	}
+
+	return pool, accs, nil
+}
+
+func getAccounts(conf *config.Config) ([]accounts.Classroom, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 1*time.Minute)
+	defer cancel()
+	accs, err := accounts.Get(ctx, conf.AccountSource(), conf.LoadLevels.Total(), conf.Sizes(), conf.PreparedPortion.Value())
+	if err != nil {
+		return nil, fmt.Errorf("couldn't get accounts: %w", err)
+	}
+
+	return accs, nil
+}
+
+// readAccounts reads all classes of the configured account source.
+func readAccounts(conf *config.Config) ([]accounts.Classroom, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 1*time.Minute)
+	defer cancel()
+	src := conf.AccountSource()
+	classes, err := accounts.Read(ctx, src)
+	if err != nil {
+		return nil, fmt.Errorf("couldn't read accounts from %s: %w", src, err)
+	}
+
+	return classes, nil
+}
+
+func shuffle(accs []accounts.Classroom) {
+	rand.Shuffle(len(accs), func(i, j int) {
+		tmp := accs[i]
+		accs[i] = accs[j]
+		accs[j] = tmp
+	})
+}
+
+func parseRunConfig(conf *config.Config, accounts []accounts.Classroom) controller.RunConfig {
+	lc := controller.LoadCurve{LoadLevels: conf.LoadLevels, StepSize: conf.StepSize}
+	return controller.RunConfig{
+		Url:       conf.Url,
+		LoadCurve: &lc,
+		Accounts:  accounts,
+	}
+}
+
+// resetDatabase resets the database as configured, either restoring the
+// whole dump or only the documents of the classes, which run needs to hold
+// the leases of. The leases of run don't keep it from restoring the whole dump.
+func resetDatabase(conf *config.Config, run string, classes []accounts.Classroom) error {
+	if conf.ResetMode() != config.ResetScoped {
+		if err := checkUnleased(conf, run); err != nil {
+			return err
+		}
+		return restoreDump(conf)
+	}
+
+	log.Println("Resetting the test accounts in MongoDB instance with dumped data from", conf.Dump)
+	ctx, cancel := context.WithTimeout(context.Background(), 1*time.Minute)
+	defer cancel()
+	deleted, restored, err := accounts.ResetScoped(ctx, conf.DbUri, conf.Dump, classes)
+	if err != nil {
+		return fmt.Errorf("failed to reset test accounts: %w", err)
+	}
+
+	for _, ns := range sortedCounts(deleted) {
+		log.Println("Deleted", deleted[ns], "documents from", ns)
+	}
+	for _, ns := range sortedCounts(restored) {
+		log.Println("Restored", restored[ns], "documents into", ns)
+	}
+	return nil
+}
+
+// checkUnleased fails if runs other than run hold leases on the database,
+// since restoring the whole dump would wipe it under them.
+func checkUnleased(conf *config.Config, run string) error {
+	leases, err := accounts.Leases(leaseFile)
+	if err != nil {
+		return fmt.Errorf("couldn't read leases: %w", err)
+	}
+
+	db := accounts.Database(conf.DbUri)
+	seen := make(map[string]bool)
+	var others []string
+	for _, l := range leases {
+		if l.Database == db && l.Run != run && !seen[l.Run] {
+			seen[l.Run] = true
+			others = append(others, l.Run)
+		}
+	}
+	if len(others) > 0 {
+		sort.Strings(others)
+		return fmt.Errorf("runs %s use %s, reset %s instead of restoring the whole dump under them: %w", strings.Join(others, ", "), db, config.ResetScoped, accounts.ErrLeased)
+	}
+	return nil
+}
+
+func sortedCounts(m map[string]int) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func restoreDump(conf *config.Config) error {
+	log.Println("Resetting MongoDB instance with dumped data from", conf.Dump)
+	ctx, cancel := context.WithTimeout(context.Background(), 1*time.Minute)
+	defer cancel()
+
+	switch conf.Restore {
+	case config.RestoreNative:
+		restored, err := accounts.RestoreNative(ctx, conf.DbUri, conf.Dump, func(c accounts.RestoredCollection) {
+			log.Println("Restoring", c.Namespace, c.Documents, "documents so far")
+		})
+		if err != nil {
+			return fmt.Errorf("failed to restore dump: %w", err)
+		}
+		for _, c := range restored {
+			log.Println("Restored", c.Documents, "documents into", c.Namespace)
+		}
+	case config.RestoreMongorestore:
+		if err := accounts.Restore(ctx, conf.DbUri, conf.Dump, conf.Debug.Value()); err != nil {
+			return fmt.Errorf("failed to restore dump: %w", err)
+		}
+	default:
+		return fmt.Errorf("unknown restore %q: %w", conf.Restore, errUsage)
+	}
+
+	return nil
+}
@@ -0,0 +1,170 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"sync"
+	"text/tabwriter"
+	"time"
+
+	"github.com/DerGut/load-tests/cmd/loadctl/config"
+	"github.com/DerGut/load-tests/controller/provisioner"
+	"github.com/DerGut/load-tests/controller/runner"
+	"github.com/DerGut/load-tests/journal"
+	"github.com/DerGut/load-tests/secrets"
+)
+
+func listRunners(fs *flag.FlagSet, args []string) error {
+	runID := fs.String("run", "", "Only list the runners of this run.")
+	_, instances, err := parseInstances(fs, args)
+	if err != nil {
+		return err
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "RUNNER\tRUN\tINSTANCE\tREGION\tAGE")
+	for _, inst := range instances {
+		if *runID != "" && runner.RunID(inst.ID()) != *runID {
+			continue
+		}
+		age := time.Since(inst.Created()).Round(time.Second)
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\n", inst.ID(), runner.RunID(inst.ID()), inst, inst.Region(), age)
+	}
+
+	return w.Flush()
+}
+
+func runnerLogs(fs *flag.FlagSet, args []string) error {
+	conf, instances, err := parseInstances(fs, args)
+	if err != nil {
+		return err
+	}
+	if fs.NArg() != 1 {
+		return errUsage
+	}
+
+	// Reading the accounts registers their passwords, so that they are
+	// redacted from the logs. Without them, there is nothing to redact.
+	readAccounts(conf)
+
+	for _, inst := range instances {
+		if inst.ID() == fs.Arg(0) {
+			ctx, cancel := context.WithTimeout(context.Background(), 1*time.Minute)
+			defer cancel()
+			return runner.FromInstance(inst).Logs(ctx, secrets.Writer(os.Stdout))
+		}
+	}
+
+	return fmt.Errorf("no runner named %s", fs.Arg(0))
+}
+
+func stopRunners(fs *flag.FlagSet, args []string) error {
+	_, instances, err := parseInstances(fs, args)
+	if err != nil {
+		return err
+	}
+	if fs.NArg() == 0 {
+		return errUsage
+	}
+
+	names := make(map[string]bool)
+	for _, name := range fs.Args() {
+		names[name] = true
+	}
+
+	var toStop []provisioner.Instance
+	for _, inst := range instances {
+		if names[inst.ID()] || names[runner.RunID(inst.ID())] {
+			toStop = append(toStop, inst)
+		}
+	}
+	if len(toStop) == 0 {
+		return fmt.Errorf("no runners found for %v", fs.Args())
+	}
+
+	return stopAll(toStop)
+}
+
+// gc destroys all instances of runs which have finished according to their
+// journal. Instances of unfinished or unknown runs are only destroyed once
+// they reach a certain age, as they might still be in use.
+func gc(fs *flag.FlagSet, args []string) error {
+	olderThan := fs.Duration("olderThan", 12*time.Hour, "The age after which instances of unfinished runs are destroyed.")
+	dryRun := fs.Bool("dryRun", false, "Only print the instances which would be destroyed.")
+	_, instances, err := parseInstances(fs, args)
+	if err != nil {
+		return err
+	}
+
+	runs, err := journal.List(journal.DefaultDir)
+	if err != nil {
+		return err
+	}
+	finished := make(map[string]bool)
+	for _, r := range runs {
+		finished[r.ID] = r.Finished()
+	}
+
+	var garbage []provisioner.Instance
+	for _, inst := range instances {
+		if finished[runner.RunID(inst.ID())] || time.Since(inst.Created()) > *olderThan {
+			garbage = append(garbage, inst)
+		}
+	}
+
+	if len(garbage) == 0 {
+		log.Println("Nothing to collect")
+		return nil
+	}
+	if *dryRun {
+		for _, inst := range garbage {
+			fmt.Println(inst.ID(), inst)
+		}
+		return nil
+	}
+
+	return stopAll(garbage)
+}
+
+// parseInstances parses the config and lists all remote runner instances.
+func parseInstances(fs *flag.FlagSet, args []string) (*config.Config, []provisioner.Instance, error) {
+	conf, err := config.Parse(fs, args)
+	if err != nil {
+		return nil, nil, err
+	}
+	if conf.DoApiKey == "" {
+		return nil, nil, fmt.Errorf("doApiKey is required: %w", errUsage)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 1*time.Minute)
+	defer cancel()
+	instances, err := newProvisioner(conf).List(ctx)
+	return conf, instances, err
+}
+
+func stopAll(instances []provisioner.Instance) error {
+	wg := sync.WaitGroup{}
+	errCh := make(chan error, len(instances))
+	for _, inst := range instances {
+		wg.Add(1)
+		go func(r *runner.RemoteClient) {
+			defer wg.Done()
+			log.Println("Stopping runner:", r)
+			if err := r.Stop(); err != nil {
+				errCh <- fmt.Errorf("failed to stop %s, please stop manually: %w", r, err)
+			}
+		}(runner.FromInstance(inst))
+	}
+	wg.Wait()
+	close(errCh)
+
+	var err error
+	for e := range errCh {
+		log.Println(e)
+		err = e
+	}
+	return err
+}
@@ -0,0 +1,133 @@
+package controller
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
+
+var ErrBudgetExceeded = errors.New("budget exceeded")
+
+// Budget limits the resources a remote run may use. A zero value disables
+// the respective limit.
+type Budget struct {
+	MaxInstances int
+	// MaxCost is given in USD.
+	MaxCost float64
+}
+
+// watchdogInterval is the interval in which the actual spend is checked.
+const watchdogInterval = 1 * time.Minute
+
+// meter keeps track of the instances of a run and their cost. All methods
+// can be called on a nil *meter, in which case nothing is tracked.
+type meter struct {
+	sync.Mutex
+	budget Budget
+	// price is the hourly price of a single instance
+	price float64
+	// end is the planned end of the run
+	end    time.Time
+	starts []time.Time
+}
+
+func newMeter(budget Budget, price float64, end time.Time) *meter {
+	return &meter{budget: budget, price: price, end: end}
+}
+
+// reserve checks whether n more instances fit into the budget until the
+// planned end of the run and records them if so.
+func (m *meter) reserve(n int) error {
+	if m == nil {
+		return nil
+	}
+	m.Lock()
+	defer m.Unlock()
+
+	if max := m.budget.MaxInstances; max > 0 && len(m.starts)+n > max {
+		return fmt.Errorf("%d more instance(s) would exceed the maximum of %d: %w", n, max, ErrBudgetExceeded)
+	}
+
+	now := time.Now()
+	projected := m.projected() + float64(n)*billedHours(m.end.Sub(now))*m.price
+	if max := m.budget.MaxCost; max > 0 && projected > max {
+		return fmt.Errorf("%d more instance(s) would raise the projected cost to $%.2f, exceeding the maximum of $%.2f: %w", n, projected, max, ErrBudgetExceeded)
+	}
+
+	for i := 0; i < n; i++ {
+		m.starts = append(m.starts, now)
+	}
+	return nil
+}
+
+// check returns an error once the spend until now exceeds the budget.
+func (m *meter) check() error {
+	if m == nil || m.budget.MaxCost <= 0 {
+		return nil
+	}
+
+	if spent := m.spent(time.Now()); spent > m.budget.MaxCost {
+		return fmt.Errorf("spent $%.2f, exceeding the maximum of $%.2f: %w", spent, m.budget.MaxCost, ErrBudgetExceeded)
+	}
+	return nil
+}
+
+// watch periodically checks the spend and sends an error to errCh once the
+// budget is exceeded, until done is closed.
+func (m *meter) watch(done <-chan struct{}, errCh chan<- error) {
+	if m == nil || m.budget.MaxCost <= 0 {
+		return
+	}
+
+	t := time.NewTicker(watchdogInterval)
+	defer t.Stop()
+	for {
+		select {
+		case <-t.C:
+			if err := m.check(); err != nil {
+				select {
+				case errCh <- err:
+				case <-done:
+				}
+				return
+			}
+		case <-done:
+			return
+		}
+	}
+}
+
+// projection returns the cost of all instances if they run until the planned end.
+func (m *meter) projection() float64 {
+	if m == nil {
+		return 0
+	}
+	m.Lock()
+	defer m.Unlock()
+
+	return m.projected()
+}
+
+func (m *meter) projected() float64 {
+	cost := 0.0
+	for _, s := range m.starts {
+		cost += billedHours(m.end.Sub(s)) * m.price
+	}
+	return cost
+}
+
+// spent returns the cost of all instances if they are stopped at the given time.
+func (m *meter) spent(at time.Time) float64 {
+	if m == nil {
+		return 0
+	}
+	m.Lock()
+	defer m.Unlock()
+
+	cost := 0.0
+	for _, s := range m.starts {
+		cost += billedHours(at.Sub(s)) * m.price
+	}
+	return cost
+}
@@ -12,24 +12,34 @@ import (
 	"github.com/DerGut/load-tests/accounts"
 	"github.com/DerGut/load-tests/controller/provisioner"
 	"github.com/DerGut/load-tests/controller/runner"
+	"github.com/DerGut/load-tests/journal"
 )
 
 type Controller interface {
 	Run(ctx context.Context, cfg RunConfig) error
+	Plan(ctx context.Context, cfg RunConfig) (*Plan, error)
 }
 type RunConfig struct {
 	Url       string
 	LoadCurve *LoadCurve
 	Accounts  []accounts.Classroom
+	// Pool has leased the accounts to the run and tracks which runner
+	// holds them, it may be nil.
+	Pool *accounts.Pool
+	// Journal records the progress of the run, it may be nil.
+	Journal *journal.Run
 }
 
 type RunnerFunc func() runner.Client
 type controller struct {
 	RunnerFunc
-	runID            string
-	classesPerRunner int
-	runners          activeRunners
-	provisioner      provisioner.Provisioner
+	runID       string
+	capacity    Capacity
+	runners     activeRunners
+	provisioner provisioner.Provisioner
+	budget      Budget
+	meter       *meter
+	scheduler   *scheduler
 }
 
 type activeRunners struct {
@@ -44,44 +54,79 @@ func NewLocal() Controller {
 		},
 		// Locally we only have one runner an it needs to support
 		// any number of classes for testing purposes
-		classesPerRunner: math.MaxInt32,
-		runners:          activeRunners{Locker: &sync.Mutex{}},
+		capacity: Capacity{ClassesPerRunner: math.MaxInt32, ContainersPerRunner: 1},
+		runners:  activeRunners{Locker: &sync.Mutex{}},
 	}
 }
 
-func NewRemote(runID string, classesPerRunner int, p provisioner.Provisioner, ddApiKey string) Controller {
+// Capacity models how many classes a runner instance hosts.
+type Capacity struct {
+	// ClassesPerRunner limits the classes of a runner unless Costs are given.
+	ClassesPerRunner int
+	// ContainersPerRunner is the number of runner containers, each with
+	// an equal share of the instance, the classes are spread across.
+	ContainersPerRunner int
+	Costs               Costs
+}
+
+func NewRemote(runID string, capacity Capacity, p provisioner.Provisioner, ddApiKey string, images runner.Images, budget Budget) Controller {
 	return &controller{
-		runID:            runID,
-		classesPerRunner: classesPerRunner,
-		runners:          activeRunners{Locker: &sync.Mutex{}},
-		provisioner:      p,
+		runID:       runID,
+		capacity:    capacity,
+		runners:     activeRunners{Locker: &sync.Mutex{}},
+		provisioner: p,
+		budget:      budget,
 		RunnerFunc: func() runner.Client {
-			return runner.NewRemote(runID, ddApiKey)
+			return runner.NewRemote(runID, ddApiKey, images, capacity.ContainersPerRunner)
 		},
 	}
 }
 
 func (c *controller) Run(ctx context.Context, cfg RunConfig) error {
-	defer c.cleanup()
+	if err := c.setup(ctx, cfg.LoadCurve); err != nil {
+		return err
+	}
+	defer func() {
+		c.collectSubmitted(cfg.Journal)
+		c.cleanup()
+		c.reportSpend(cfg.Journal)
+	}()
 
-	errCh := make(chan error)
+	// Buffered for every step and the watchdog, so that no sender blocks after we returned
+	errCh := make(chan error, len(cfg.LoadCurve.LoadLevels)+1)
 	wg := sync.WaitGroup{}
 	defer wg.Wait()
 
+	done := make(chan struct{})
+	defer close(done)
+	go c.meter.watch(done, errCh)
+
 	accountIdx := 0
 	currentLoad := 0
 	for _, load := range cfg.LoadCurve.LoadLevels {
 		log.Println("Next step with", load, "running classes")
+		step := cfg.Journal.AddStep(load)
 		toAdd := load - currentLoad
 		if toAdd < 0 {
-			panic("No Load decrease implemented yet")
+			wg.Add(1)
+			go func(n int) {
+				if err := c.removeClasses(ctx, cfg.Pool, n); err != nil {
+					errCh <- err
+				}
+				wg.Done()
+			}(-toAdd)
 		}
 
+		// Removed classes aren't reused, since their accounts aren't in their seed state anymore
 		if toAdd > 0 {
 			wg.Add(1)
 			batch := cfg.Accounts[accountIdx : accountIdx+toAdd]
 			go func(b []accounts.Classroom) {
-				err := c.nextStep(ctx, c.runID, cfg.Url, b)
+				runners, err := c.nextStep(ctx, &cfg, b)
+				cfg.Journal.AddRunners(step, runnerNames(runners)...)
+				for _, r := range runners {
+					cfg.Journal.SetRegion(r.String(), r.Region())
+				}
 				if err != nil {
 					errCh <- err
 				}
@@ -105,61 +150,215 @@ func (c *controller) Run(ctx context.Context, cfg RunConfig) error {
 	return nil
 }
 
-func (c *controller) nextStep(ctx context.Context, runID string, url string, accs []accounts.Classroom) error {
-	accsByRunner := batchAccounts(accs, c.classesPerRunner)
-
-	log.Println("Starting", len(accsByRunner), "runner(s) with", len(accs), "classes in total")
-	runners, err := c.startRunners(ctx, runID, url, accsByRunner)
+func (c *controller) nextStep(ctx context.Context, cfg *RunConfig, accs []accounts.Classroom) ([]runner.Client, error) {
+	sched, err := c.scheduler.schedule(accs)
 	if err != nil {
-		return err
+		return nil, err
 	}
+	if err := c.meter.reserve(len(sched.runners)); err != nil {
+		return nil, err
+	}
+
+	log.Println("Starting", len(sched.runners), "runner(s) and extending", len(sched.extensions), "container(s) of running ones with", len(accs), "classes in total")
+	extErr := c.extendRunners(ctx, sched.extensions)
+	runners, failed, err := c.startRunners(ctx, c.runID, cfg.Url, sched.runners)
+	c.scheduler.running(sched, failed...)
+	if err == nil {
+		err = extErr
+	}
+	holdClasses(cfg.Pool, sched, failed)
 
 	c.runners.Lock()
 	defer c.runners.Unlock()
 	c.runners.active = append(c.runners.active, runners...)
-	return nil
+	return runners, err
 }
 
-func batchAccounts(accs []accounts.Classroom, classesPerRunner int) [][]accounts.Classroom {
-	var batches [][]accounts.Classroom
-	for i := 0; i < len(accs); i += classesPerRunner {
-		if i+classesPerRunner > len(accs) {
-			batches = append(batches, accs[i:])
-		} else {
-			batches = append(batches, accs[i:i+classesPerRunner])
+// holdClasses records which runner holds the classes of the schedule.
+func holdClasses(pool *accounts.Pool, sched *stepSchedule, failed []*scheduledRunner) {
+	held := make(map[string][]accounts.Classroom)
+	for _, e := range sched.extensions {
+		name := e.runner.client.String()
+		held[name] = append(held[name], e.classes...)
+	}
+	isFailed := make(map[*scheduledRunner]bool, len(failed))
+	for _, f := range failed {
+		isFailed[f] = true
+	}
+	for _, r := range sched.runners {
+		if isFailed[r.scheduledRunner] {
+			continue
+		}
+		held[r.client.String()] = append(held[r.client.String()], flatten(r.classes)...)
+	}
+
+	for name, classes := range held {
+		if err := pool.Hold(name, classes); err != nil {
+			log.Println("Couldn't record that", name, "holds", len(classes), "classes:", err)
+		}
+	}
+}
+
+// extendRunners adds classes to running runners, starting the containers
+// which aren't running yet.
+func (c *controller) extendRunners(ctx context.Context, extensions []extension) error {
+	errCh := make(chan error, len(extensions))
+	for _, e := range extensions {
+		go func(e extension) {
+			if e.running {
+				errCh <- e.runner.client.AddClasses(ctx, e.container, e.classes)
+			} else {
+				errCh <- e.runner.client.StartContainer(ctx, e.container, e.classes)
+			}
+		}(e)
+	}
+
+	var err error
+	for range extensions {
+		if e := <-errCh; e != nil {
+			log.Println("Error while extending runner:", e)
+			err = e
+		}
+	}
+	return err
+}
+
+// removeClasses stops the n most recently started classes and returns them
+// to the pool.
+func (c *controller) removeClasses(ctx context.Context, pool *accounts.Pool, n int) error {
+	removals := c.scheduler.release(n)
+	removed := 0
+	for _, r := range removals {
+		removed += len(r.classes)
+	}
+	if removed < n {
+		log.Println("Only", removed, "of", n, "classes can be removed, the others are still starting")
+	}
+
+	results := make(chan removalResult, len(removals))
+	for _, r := range removals {
+		go func(r removal) {
+			results <- removalResult{classes: r.classes, err: r.runner.client.RemoveClasses(ctx, r.container, r.classes)}
+		}(r)
+	}
+
+	var err error
+	for range removals {
+		res := <-results
+		if res.err != nil {
+			log.Println("Error while removing classes:", res.err)
+			err = res.err
+			continue
+		}
+		// Other runs may lease them now, this one doesn't reuse them anyway
+		if e := pool.Release(res.classes...); e != nil {
+			log.Println("Couldn't release", len(res.classes), "removed classes:", e)
+		}
+	}
+	return err
+}
+
+// removalResult is the outcome of removing the classes from a runner.
+type removalResult struct {
+	classes []accounts.Classroom
+	err     error
+}
+
+// setup prepares scheduling and, for remote runs, metering the instances
+// against the budget.
+func (c *controller) setup(ctx context.Context, lc *LoadCurve) error {
+	size, err := c.size(ctx)
+	if err != nil {
+		return err
+	}
+	if c.provisioner != nil {
+		c.meter = newMeter(c.budget, size.PriceHourly, time.Now().Add(lc.Duration()))
+	}
+
+	c.scheduler, err = newScheduler(c.capacity, size)
+	return err
+}
+
+// size looks up the size of remote instances. It is only required for
+// enforcing the maximum cost and for scheduling by costs.
+func (c *controller) size(ctx context.Context) (provisioner.Size, error) {
+	if c.provisioner == nil {
+		return provisioner.Size{}, nil
+	}
+
+	size, err := c.provisioner.Size(ctx)
+	if err != nil {
+		if c.budget.MaxCost > 0 {
+			return size, fmt.Errorf("can't enforce maximum cost without the instance price: %w", err)
+		}
+		if c.capacity.Costs.enabled() {
+			return size, fmt.Errorf("can't schedule by costs without the instance size: %w", err)
 		}
+		log.Println("Couldn't look up instance price, not tracking spend:", err)
+	}
+
+	return size, nil
+}
+
+func (c *controller) reportSpend(j *journal.Run) {
+	if c.meter == nil {
+		return
 	}
 
-	return batches
+	projected, actual := c.meter.projection(), c.meter.spent(time.Now())
+	log.Printf("Projected spend: $%.2f, actual spend: $%.2f\n", projected, actual)
+	j.SetSpend(projected, actual)
+}
+
+func runnerNames(runners []runner.Client) []string {
+	names := make([]string, len(runners))
+	for i, r := range runners {
+		names[i] = r.String()
+	}
+	return names
 }
 
 type runnerResult struct {
-	runner.Client
+	*scheduledRunner
 	err error
 }
 
-func (c *controller) startRunners(ctx context.Context, runID, url string, accsByRunner [][]accounts.Classroom) ([]runner.Client, error) {
+// startRunners starts the new runners of a step. It returns those which
+// have been started and those which failed.
+func (c *controller) startRunners(ctx context.Context, runID, url string, planned []newRunner) ([]runner.Client, []*scheduledRunner, error) {
 	ctx, cancel := context.WithCancel(ctx)
 	defer cancel()
 
-	ch := make(chan runnerResult, len(accsByRunner))
-	for _, accs := range accsByRunner {
-		r := c.RunnerFunc()
-		s := runner.Step{Url: url, Accounts: accs}
-		go func(step *runner.Step) {
-			if err := r.Start(ctx, step, c.provisioner); err != nil {
-				ch <- runnerResult{nil, err}
-			} else {
-				ch <- runnerResult{r, nil}
-			}
-		}(&s)
+	if len(planned) == 0 {
+		return nil, nil, nil
+	}
+
+	clients := make([]runner.Client, len(planned))
+	failed := make([]*scheduledRunner, len(planned))
+	for i := range clients {
+		clients[i] = c.RunnerFunc()
+		failed[i] = planned[i].scheduledRunner
+	}
+	instances, err := c.provision(ctx, clients)
+	if err != nil {
+		return nil, failed, fmt.Errorf("failed to provision instances: %w", err)
+	}
+
+	ch := make(chan runnerResult, len(planned))
+	for i, p := range planned {
+		p.client = clients[i]
+		s := runner.Step{Url: url, Containers: p.classes}
+		go func(r *scheduledRunner, inst provisioner.Instance, step *runner.Step) {
+			ch <- runnerResult{r, r.client.Start(ctx, step, inst)}
+		}(p.scheduledRunner, instances[i], &s)
 	}
 
 	var runners []runner.Client
-	var err error
-	for i := 0; i < len(accsByRunner); i++ {
+	failed = nil
+	for i := 0; i < len(planned); i++ {
 		r := <-ch
 		if r.err != nil {
+			failed = append(failed, r.scheduledRunner)
 			if errors.Is(r.err, context.Canceled) {
 				continue
 			}
@@ -167,15 +366,69 @@ func (c *controller) startRunners(ctx context.Context, runID, url string, accsBy
 			err = r.err
 			cancel()
 		} else {
-			runners = append(runners, r.Client)
+			runners = append(runners, r.client)
 		}
 	}
 
 	if err != nil {
-		return nil, fmt.Errorf("error occured while starting runner: %w", err)
+		// The runners which did start still need to be cleaned up
+		return runners, failed, fmt.Errorf("error occured while starting runner: %w", err)
+	}
+
+	return runners, failed, nil
+}
+
+// provision creates an instance for each of the clients, using a single
+// request for several of them. Local runners don't need any instance.
+// All clients share the same user data, so the first one's is used.
+func (c *controller) provision(ctx context.Context, clients []runner.Client) ([]provisioner.Instance, error) {
+	if c.provisioner == nil {
+		return make([]provisioner.Instance, len(clients)), nil
+	}
+
+	userData, err := clients[0].UserData()
+	if err != nil {
+		return nil, err
+	}
+
+	if len(clients) == 1 {
+		inst, err := c.provisioner.Provision(ctx, clients[0].String(), userData)
+		if err != nil {
+			return nil, err
+		}
+		return []provisioner.Instance{inst}, nil
+	}
+
+	ids := make([]string, len(clients))
+	for i, r := range clients {
+		ids[i] = r.String()
+	}
+	return c.provisioner.ProvisionMany(ctx, ids, userData)
+}
+
+// collectSubmitted records what the pupils of all runners have submitted,
+// before the runners are stopped.
+func (c *controller) collectSubmitted(j *journal.Run) {
+	if j == nil {
+		return
 	}
+	ctx, cancel := context.WithTimeout(context.Background(), 1*time.Minute)
+	defer cancel()
 
-	return runners, nil
+	c.runners.Lock()
+	defer c.runners.Unlock()
+	for _, r := range c.runners.active {
+		submitted, err := r.Submitted(ctx)
+		if err != nil {
+			log.Println("Failed to collect submissions of", r, err)
+			continue
+		}
+		converted := make(map[string]journal.Submissions, len(submitted))
+		for pupil, s := range submitted {
+			converted[pupil] = journal.Submissions{Exercises: s.Exercises, TaskSeries: s.TaskSeries}
+		}
+		j.AddSubmitted(converted)
+	}
 }
 
 func (c *controller) cleanup() {
@@ -14,6 +14,12 @@ type LoadCurve struct {
 	StepSize
 }
 
+// Duration returns the total duration of a run following the curve. It
+// includes one additional step for the graceful shutdown of all runners.
+func (lc *LoadCurve) Duration() time.Duration {
+	return time.Duration(len(lc.LoadLevels)+1) * lc.StepSize.Duration
+}
+
 type LoadLevels []int
 type StepSize struct {
 	time.Duration
@@ -40,6 +46,33 @@ func (ll *LoadLevels) String() string {
 	return fmt.Sprint(*ll)
 }
 
+// Max returns the highest class concurrency of all load levels.
+func (ll LoadLevels) Max() int {
+	max := 0
+	for _, l := range ll {
+		if l > max {
+			max = l
+		}
+	}
+
+	return max
+}
+
+// Total returns the number of classes started throughout the run. Classes
+// removed on a load decrease aren't restarted later on, so each increase
+// needs classes of its own.
+func (ll LoadLevels) Total() int {
+	total, current := 0, 0
+	for _, l := range ll {
+		if l > current {
+			total += l - current
+		}
+		current = l
+	}
+
+	return total
+}
+
 func (ss *StepSize) MarshalJSON() ([]byte, error) {
 	return json.Marshal(ss.String())
 }
@@ -0,0 +1,152 @@
+package controller
+
+import (
+	"context"
+	"math"
+	"time"
+
+	"github.com/DerGut/load-tests/accounts"
+)
+
+// Plan describes what a run would do, without provisioning anything.
+type Plan struct {
+	Steps []PlannedStep
+	// Shutdown is the time at which the runners are stopped.
+	Shutdown time.Duration
+	// Runtime is the total duration of the run including the graceful shutdown.
+	Runtime time.Duration
+	// Remote is true if each runner is deployed to its own instance.
+	Remote bool
+}
+
+// PlannedStep is a single step of the load curve.
+type PlannedStep struct {
+	Start time.Duration
+	Load  int
+	// Runners holds the classes for each runner started in this step.
+	Runners [][]accounts.Classroom
+	// Extended holds the classes placed onto spare containers of runners
+	// started in earlier steps.
+	Extended []accounts.Classroom
+	// Removed holds the classes stopped on a load decrease.
+	Removed []accounts.Classroom
+}
+
+func (c *controller) Plan(ctx context.Context, cfg RunConfig) (*Plan, error) {
+	size, err := c.size(ctx)
+	if err != nil {
+		return nil, err
+	}
+	sched, err := newScheduler(c.capacity, size)
+	if err != nil {
+		return nil, err
+	}
+
+	p := Plan{
+		Shutdown: time.Duration(len(cfg.LoadCurve.LoadLevels)) * cfg.LoadCurve.StepSize.Duration,
+		Runtime:  cfg.LoadCurve.Duration(),
+		Remote:   c.provisioner != nil,
+	}
+
+	accountIdx := 0
+	currentLoad := 0
+	for i, load := range cfg.LoadCurve.LoadLevels {
+		step := PlannedStep{
+			Start: time.Duration(i) * cfg.LoadCurve.StepSize.Duration,
+			Load:  load,
+		}
+		if toAdd := load - currentLoad; toAdd > 0 {
+			s, err := sched.schedule(cfg.Accounts[accountIdx : accountIdx+toAdd])
+			if err != nil {
+				return nil, err
+			}
+			sched.running(s)
+			for _, r := range s.runners {
+				step.Runners = append(step.Runners, flatten(r.classes))
+			}
+			for _, e := range s.extensions {
+				step.Extended = append(step.Extended, e.classes...)
+			}
+			accountIdx += toAdd
+		} else if toAdd < 0 {
+			for _, r := range sched.release(-toAdd) {
+				step.Removed = append(step.Removed, r.classes...)
+			}
+		}
+		currentLoad = load
+		p.Steps = append(p.Steps, step)
+	}
+
+	return &p, nil
+}
+
+func flatten(containers [][]accounts.Classroom) []accounts.Classroom {
+	var classes []accounts.Classroom
+	for _, c := range containers {
+		classes = append(classes, c...)
+	}
+	return classes
+}
+
+// Runners returns the total number of runners started throughout the run.
+func (p *Plan) Runners() int {
+	n := 0
+	for _, s := range p.Steps {
+		n += len(s.Runners)
+	}
+	return n
+}
+
+// PeakClasses returns the highest number of concurrently running classes.
+func (p *Plan) PeakClasses() int {
+	peak := 0
+	for _, s := range p.Steps {
+		if s.Load > peak {
+			peak = s.Load
+		}
+	}
+	return peak
+}
+
+// PeakVUs returns the highest number of concurrently running virtual users.
+// Each of them runs in a separate browser.
+func (p *Plan) PeakVUs() int {
+	peak, vus := 0, 0
+	for _, s := range p.Steps {
+		for _, classes := range s.Runners {
+			vus += countVUs(classes)
+		}
+		vus += countVUs(s.Extended)
+		vus -= countVUs(s.Removed)
+		if vus > peak {
+			peak = vus
+		}
+	}
+	return peak
+}
+
+func countVUs(classes []accounts.Classroom) int {
+	vus := 0
+	for _, c := range classes {
+		vus += len(c.Pupils) + 1 // pupils and teacher
+	}
+	return vus
+}
+
+// InstanceHours returns the number of billed instance-hours. Instances
+// are billed per started hour and live from their step until the end.
+func (p *Plan) InstanceHours() float64 {
+	if !p.Remote {
+		return 0
+	}
+
+	hours := 0.0
+	for _, s := range p.Steps {
+		hours += float64(len(s.Runners)) * billedHours(p.Runtime-s.Start)
+	}
+	return hours
+}
+
+func billedHours(d time.Duration) float64 {
+	return math.Ceil(d.Hours())
+}
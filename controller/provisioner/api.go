@@ -0,0 +1,208 @@
+package provisioner
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"math"
+	"math/rand"
+	"net"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/digitalocean/godo"
+	"golang.org/x/time/rate"
+)
+
+const (
+	// DigitalOcean allows 250 requests per minute, stay well below to
+	// leave room for other clients using the same token.
+	apiRequestsPerSecond = 3
+	apiBurst             = 10
+
+	// maxConcurrentProvisions limits the number of droplets being created
+	// and waited for at the same time.
+	maxConcurrentProvisions = 5
+
+	apiMaxTries       = 6
+	apiBackoffInitial = 2 * time.Second
+	apiBackoffMax     = 1 * time.Minute
+)
+
+// api wraps a godo client. It rate limits all requests with a token bucket
+// and retries them on rate limit responses and transient failures.
+type api struct {
+	client  *godo.Client
+	limiter *rate.Limiter
+	debug   bool
+}
+
+func newAPI(apiToken string, debug bool) *api {
+	return &api{
+		client:  godo.NewFromToken(apiToken),
+		limiter: rate.NewLimiter(apiRequestsPerSecond, apiBurst),
+		debug:   debug,
+	}
+}
+
+// call invokes fn until it succeeds, fails permanently or the retries are exhausted.
+func (a *api) call(ctx context.Context, fn func(*godo.Client) (*godo.Response, error)) error {
+	var err error
+	for try := 0; try < apiMaxTries; try++ {
+		if err := a.limiter.Wait(ctx); err != nil {
+			return err
+		}
+
+		var resp *godo.Response
+		resp, err = fn(a.client)
+		if err == nil {
+			return nil
+		}
+
+		wait, retry := retryAfter(resp, err, try)
+		if !retry {
+			return err
+		}
+		if a.debug {
+			log.Println("DigitalOcean API request failed, trying again in", wait, "-", err)
+		}
+
+		select {
+		case <-time.After(wait):
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+
+	return fmt.Errorf("giving up after %d tries: %w", apiMaxTries, err)
+}
+
+// create invokes fn like call, but for requests creating resources, which
+// aren't idempotent. They are retried right away only if they can't have
+// been processed, on rate limit responses and failures to connect. After
+// any other transient failure, exists reports whether the resources have
+// been created nonetheless, before the request is retried.
+func (a *api) create(ctx context.Context, fn func(*godo.Client) (*godo.Response, error), exists func(context.Context) (bool, error)) error {
+	var err error
+	for try := 0; try < apiMaxTries; try++ {
+		if err := a.limiter.Wait(ctx); err != nil {
+			return err
+		}
+
+		var resp *godo.Response
+		resp, err = fn(a.client)
+		if err == nil {
+			return nil
+		}
+
+		wait, retry := retryAfter(resp, err, try)
+		if !retry {
+			return err
+		}
+		if a.debug {
+			log.Println("DigitalOcean API create request failed, trying again in", wait, "-", err)
+		}
+
+		select {
+		case <-time.After(wait):
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+
+		if notSent(resp, err) {
+			continue
+		}
+		ok, lookupErr := exists(ctx)
+		if lookupErr != nil {
+			return fmt.Errorf("%w, couldn't check whether it has been created nonetheless: %v", err, lookupErr)
+		}
+		if ok {
+			return nil
+		}
+	}
+
+	return fmt.Errorf("giving up after %d tries: %w", apiMaxTries, err)
+}
+
+// notSent reports whether a failed request can't have been processed by the API.
+func notSent(resp *godo.Response, err error) bool {
+	if resp != nil && resp.Response != nil {
+		return resp.StatusCode == http.StatusTooManyRequests
+	}
+
+	var dnsErr *net.DNSError
+	if errors.As(err, &dnsErr) {
+		return true
+	}
+	var opErr *net.OpError
+	return errors.As(err, &opErr) && opErr.Op == "dial"
+}
+
+// retryAfter decides whether a failed request should be retried and how long to wait before.
+func retryAfter(resp *godo.Response, err error, try int) (time.Duration, bool) {
+	if errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return 0, false
+	}
+
+	if resp == nil || resp.Response == nil {
+		var netErr net.Error
+		if errors.As(err, &netErr) {
+			return backoff(try), true
+		}
+		return 0, false
+	}
+
+	switch code := resp.StatusCode; {
+	case code == http.StatusTooManyRequests:
+		if s, err := strconv.Atoi(resp.Header.Get("Retry-After")); err == nil {
+			return time.Duration(s) * time.Second, true
+		}
+		if reset := time.Until(resp.Rate.Reset.Time); reset > 0 && resp.Rate.Remaining == 0 {
+			return reset, true
+		}
+		return backoff(try), true
+	case code >= 500:
+		return backoff(try), true
+	default:
+		return 0, false
+	}
+}
+
+// backoff returns an exponentially growing duration with full jitter.
+func backoff(try int) time.Duration {
+	max := float64(apiBackoffInitial) * math.Pow(2, float64(try))
+	if max > float64(apiBackoffMax) {
+		max = float64(apiBackoffMax)
+	}
+	return time.Duration(rand.Int63n(int64(max)) + 1)
+}
+
+// waitForAction polls the action until it has completed.
+func (a *api) waitForAction(ctx context.Context, actionID int) error {
+	for {
+		var action *godo.Action
+		err := a.call(ctx, func(c *godo.Client) (resp *godo.Response, err error) {
+			action, resp, err = c.Actions.Get(ctx, actionID)
+			return resp, err
+		})
+		if err != nil {
+			return err
+		}
+
+		switch action.Status {
+		case godo.ActionCompleted:
+			return nil
+		case godo.ActionInProgress:
+		default:
+			return fmt.Errorf("action %d %s", actionID, action.Status)
+		}
+
+		select {
+		case <-time.After(5 * time.Second):
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
@@ -1,115 +1,614 @@
 package provisioner
 
 import (
+	"bytes"
 	"context"
 	"errors"
 	"fmt"
 	"log"
 	"math"
 	"os"
+	"path/filepath"
+	"strings"
+	"sync"
 	"time"
 
 	"github.com/DerGut/load-tests/ssh"
 	"github.com/digitalocean/godo"
-	"github.com/digitalocean/godo/util"
 )
 
 const (
 	defaultUser    = "root"
 	defaultSSHPort = "22"
+	// instanceTag is attached to all runner droplets in order to find them again
+	instanceTag = "load-tests"
+	// buildTag is attached to the droplets images are built on instead, so
+	// that they are never mistaken for runners and destroyed mid-build.
+	buildTag = "load-tests-image-build"
 )
 
 type doProvisioner struct {
-	apiToken    string
-	region      string
+	api         *api
+	regions     *spreader
 	dropletSize string
+	image       godo.DropletCreateImage
 	sshKeyIDs   []godo.DropletCreateSSHKey
-	debug       bool
+	// tag is attached to all droplets of the provisioner, which List returns.
+	tag   string
+	debug bool
+	// pool bounds the number of concurrent droplet creations
+	pool chan struct{}
 }
 
-func NewDO(apiToken, region, dropletSize string, debug bool) Provisioner {
+// NewDO returns a Provisioner for DigitalOcean droplets, which are spread
+// across the regions by their weights. The image is either the ID of a
+// custom image or the slug of a public one, the stock docker image is used
+// if it is empty.
+func NewDO(apiToken string, regions Regions, dropletSize, image string, debug bool) Provisioner {
 	return &doProvisioner{
-		apiToken:    apiToken,
-		region:      region,
+		api:         newAPI(apiToken, debug),
+		regions:     newSpreader(regions),
 		dropletSize: dropletSize,
+		image:       dropletImage(image),
 		sshKeyIDs: []godo.DropletCreateSSHKey{
 			{ID: 22074350},
 			{ID: 26570780},
 		},
+		tag:   instanceTag,
 		debug: debug,
+		pool:  make(chan struct{}, maxConcurrentProvisions),
 	}
 }
 
-func (dop *doProvisioner) Provision(ctx context.Context, instanceID string) (Instance, error) {
-	client := godo.NewFromToken(dop.apiToken)
-
+func (dop *doProvisioner) Provision(ctx context.Context, instanceID, userData string) (Instance, error) {
+	region := dop.regions.next()
 	req := godo.DropletCreateRequest{
-		Name:       fmt.Sprintf("do-%s-%s-%s", dop.dropletSize, dop.region, instanceID),
-		Region:     dop.region,
+		Name:       dropletName(dop.dropletSize, region, instanceID),
+		Region:     region,
 		Size:       dop.dropletSize,
-		Image:      godo.DropletCreateImage{Slug: "docker-20-04"},
+		Image:      dop.image,
 		SSHKeys:    dop.sshKeyIDs,
-		Tags:       []string{instanceID},
+		Tags:       []string{dop.tag},
 		Monitoring: true,
+		UserData:   userData,
 	}
 
-	log.Println("Creating", req.Name)
-	d, err := createDroplet(ctx, client, &req)
+	d, err := dop.createDropletWithRetries(ctx, &req)
 	if err != nil {
 		return nil, err
 	}
 
+	inst := &doInstance{p: dop, id: instanceID, droplet: d, debug: dop.debug}
 	if err = waitForReachable(ctx, d, dop.debug); err != nil {
 		log.Println("Destroying unready droplet:", d.Name)
-		if _, errDel := client.Droplets.Delete(context.TODO(), d.ID); errDel != nil {
+		if errDel := inst.Destroy(); errDel != nil {
 			log.Printf("Couldn't destroy droplet %s, please do so manually\n", d.Name)
 		}
 		return nil, err
 	}
 
-	return &doInstance{apiToken: dop.apiToken, droplet: d, debug: dop.debug}, nil
+	return inst, nil
 }
 
-func createDroplet(ctx context.Context, c *godo.Client, dcr *godo.DropletCreateRequest) (*godo.Droplet, error) {
-	d, resp, err := c.Droplets.Create(ctx, dcr)
+// multiCreateLimit is the maximum number of droplets created by a single request.
+const multiCreateLimit = 10
+
+// ProvisionMany creates droplets in batches of up to ten per request and
+// region and waits for all of them at once. Droplets which don't become
+// active are replaced one by one, retrying like Provision. If any of them
+// fails nonetheless, all of them are destroyed.
+func (dop *doProvisioner) ProvisionMany(ctx context.Context, instanceIDs []string, userData string) ([]Instance, error) {
+	var droplets []*godo.Droplet
+	destroyAll := func() {
+		for _, d := range droplets {
+			log.Println("Destroying droplet:", d.Name)
+			if err := dop.deleteDroplet(d.ID); err != nil {
+				log.Printf("Couldn't destroy droplet %s, please do so manually\n", d.Name)
+			}
+		}
+	}
+
+	regions := make([]string, len(instanceIDs))
+	byRegion := make(map[string][]string)
+	regionOf := make(map[string]string, len(instanceIDs))
+	for i, id := range instanceIDs {
+		regions[i] = dop.regions.next()
+		byRegion[regions[i]] = append(byRegion[regions[i]], id)
+		regionOf[dropletName(dop.dropletSize, regions[i], id)] = regions[i]
+	}
+
+	for region, ids := range byRegion {
+		for i := 0; i < len(ids); i += multiCreateLimit {
+			end := i + multiCreateLimit
+			if end > len(ids) {
+				end = len(ids)
+			}
+
+			created, err := dop.createDroplets(ctx, region, ids[i:end], userData)
+			droplets = append(droplets, created...)
+			if err != nil {
+				destroyAll()
+				return nil, err
+			}
+		}
+	}
+
+	inactive, err := dop.waitForActive(ctx, droplets)
+	if err == nil && len(inactive) > 0 {
+		var replaced []*godo.Droplet
+		droplets = without(droplets, inactive)
+		replaced, err = dop.replaceDroplets(ctx, inactive, regionOf, userData)
+		droplets = append(droplets, replaced...)
+	}
 	if err != nil {
+		destroyAll()
 		return nil, err
 	}
 
-	var action *godo.LinkAction
-	for _, a := range resp.Links.Actions {
-		if a.Rel == "create" {
-			action = &a
+	byName := make(map[string]*godo.Droplet, len(droplets))
+	for _, d := range droplets {
+		byName[d.Name] = d
+	}
+
+	instances := make([]Instance, len(instanceIDs))
+	errCh := make(chan error, len(instanceIDs))
+	wg := sync.WaitGroup{}
+	for i, id := range instanceIDs {
+		d, ok := byName[dropletName(dop.dropletSize, regions[i], id)]
+		if !ok {
+			destroyAll()
+			return nil, fmt.Errorf("no droplet has been created for %s", id)
+		}
+		instances[i] = &doInstance{p: dop, id: id, droplet: d, debug: dop.debug}
+		wg.Add(1)
+		go func(d *godo.Droplet) {
+			defer wg.Done()
+			if err := waitForReachable(ctx, d, dop.debug); err != nil {
+				errCh <- fmt.Errorf("%s: %w", d.Name, err)
+			}
+		}(d)
+	}
+	wg.Wait()
+	close(errCh)
+
+	if err := <-errCh; err != nil {
+		destroyAll()
+		return nil, err
+	}
+
+	return instances, nil
+}
+
+// replaceDroplets destroys the droplets and creates each of them anew in its
+// region by name, retrying droplets which don't become active again. The
+// replacements created so far are returned in any case.
+func (dop *doProvisioner) replaceDroplets(ctx context.Context, droplets []*godo.Droplet, regionOf map[string]string, userData string) ([]*godo.Droplet, error) {
+	type result struct {
+		d   *godo.Droplet
+		err error
+	}
+	results := make(chan result, len(droplets))
+	for _, d := range droplets {
+		go func(d *godo.Droplet) {
+			log.Println("Replacing droplet which didn't become active:", d.Name)
+			if err := dop.deleteDroplet(d.ID); err != nil {
+				log.Printf("Couldn't destroy droplet %s, please do so manually\n", d.Name)
+			}
+			req := godo.DropletCreateRequest{
+				Name:       d.Name,
+				Region:     regionOf[d.Name],
+				Size:       dop.dropletSize,
+				Image:      dop.image,
+				SSHKeys:    dop.sshKeyIDs,
+				Tags:       []string{dop.tag},
+				Monitoring: true,
+				UserData:   userData,
+			}
+			replaced, err := dop.createDropletWithRetries(ctx, &req)
+			results <- result{replaced, err}
+		}(d)
+	}
+
+	var replaced []*godo.Droplet
+	var err error
+	for range droplets {
+		r := <-results
+		if r.err != nil {
+			err = r.err
+			continue
+		}
+		replaced = append(replaced, r.d)
+	}
+	return replaced, err
+}
+
+// without returns the droplets except for the removed ones.
+func without(droplets, removed []*godo.Droplet) []*godo.Droplet {
+	isRemoved := make(map[int]bool, len(removed))
+	for _, d := range removed {
+		isRemoved[d.ID] = true
+	}
+	var kept []*godo.Droplet
+	for _, d := range droplets {
+		if !isRemoved[d.ID] {
+			kept = append(kept, d)
+		}
+	}
+	return kept
+}
+
+func (dop *doProvisioner) createDroplets(ctx context.Context, region string, instanceIDs []string, userData string) ([]*godo.Droplet, error) {
+	select {
+	case dop.pool <- struct{}{}:
+		defer func() { <-dop.pool }()
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+
+	names := make([]string, len(instanceIDs))
+	for i, id := range instanceIDs {
+		names[i] = dropletName(dop.dropletSize, region, id)
+	}
+	req := godo.DropletMultiCreateRequest{
+		Names:      names,
+		Region:     region,
+		Size:       dop.dropletSize,
+		Image:      dop.image,
+		SSHKeys:    dop.sshKeyIDs,
+		Tags:       []string{dop.tag},
+		Monitoring: true,
+		UserData:   userData,
+	}
+
+	log.Println("Creating", strings.Join(names, ", "))
+	var created []godo.Droplet
+	err := dop.api.create(ctx, func(c *godo.Client) (r *godo.Response, err error) {
+		created, r, err = c.Droplets.CreateMultiple(ctx, &req)
+		return r, err
+	}, func(ctx context.Context) (bool, error) {
+		// Any droplets found are returned, so that they are destroyed if some are missing
+		found, err := dop.findDroplets(ctx, names)
+		if err != nil || len(found) == 0 {
+			return false, err
+		}
+		created = found
+		return true, nil
+	})
+
+	droplets := make([]*godo.Droplet, len(created))
+	for i := range created {
+		droplets[i] = &created[i]
+	}
+	return droplets, err
+}
+
+// activeTimeout is the time droplets are given to become active.
+const activeTimeout = 5 * time.Minute
+
+// waitForActive polls all droplets with a single request per interval until
+// each of them has become active. The droplets are updated in place. Those
+// which haven't become active within the activeTimeout are returned.
+func (dop *doProvisioner) waitForActive(parent context.Context, droplets []*godo.Droplet) ([]*godo.Droplet, error) {
+	ctx, cancel := context.WithTimeout(parent, activeTimeout)
+	defer cancel()
+
+	pending := make(map[int]*godo.Droplet, len(droplets))
+	for _, d := range droplets {
+		pending[d.ID] = d
+	}
+	// stopped returns the pending droplets once the activeTimeout expired, the error otherwise
+	stopped := func(err error) ([]*godo.Droplet, error) {
+		if parent.Err() != nil {
+			return nil, parent.Err()
+		}
+		if ctx.Err() == nil {
+			return nil, err
+		}
+		inactive := make([]*godo.Droplet, 0, len(pending))
+		for _, d := range pending {
+			inactive = append(inactive, d)
+		}
+		return inactive, nil
+	}
+
+	for len(pending) > 0 {
+		select {
+		case <-time.After(5 * time.Second):
+		case <-ctx.Done():
+			return stopped(ctx.Err())
+		}
+
+		opt := &godo.ListOptions{PerPage: 200}
+		for {
+			var current []godo.Droplet
+			var resp *godo.Response
+			err := dop.api.call(ctx, func(c *godo.Client) (r *godo.Response, err error) {
+				current, resp, err = c.Droplets.ListByTag(ctx, dop.tag, opt)
+				return resp, err
+			})
+			if err != nil {
+				return stopped(err)
+			}
+			for _, c := range current {
+				if d, ok := pending[c.ID]; ok && c.Status == "active" {
+					*d = c
+					delete(pending, c.ID)
+				}
+			}
+
+			if resp.Links == nil || resp.Links.IsLastPage() {
+				break
+			}
+			page, err := resp.Links.CurrentPage()
+			if err != nil {
+				return nil, err
+			}
+			opt.Page = page + 1
+		}
+	}
+
+	return nil, nil
+}
+
+// createTries is the number of attempts to create a droplet. API requests are
+// retried on their own, this additionally covers droplets failing to become active.
+const createTries = 3
+
+func (dop *doProvisioner) createDropletWithRetries(ctx context.Context, req *godo.DropletCreateRequest) (*godo.Droplet, error) {
+	select {
+	case dop.pool <- struct{}{}:
+		defer func() { <-dop.pool }()
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+
+	var err error
+	for try := 0; try < createTries; try++ {
+		log.Println("Creating", req.Name)
+		var d *godo.Droplet
+		d, err = dop.createDroplet(ctx, req)
+		if err == nil {
+			return d, nil
+		}
+		if !errors.Is(err, errNotActive) {
+			return nil, err
+		}
+
+		wait := backoff(try)
+		log.Println("Failed creating", req.Name, "trying again in", wait.Round(time.Second), "-", err)
+		select {
+		case <-time.After(wait):
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+
+	return nil, err
+}
+
+func (dop *doProvisioner) List(ctx context.Context) ([]Instance, error) {
+	var instances []Instance
+	opt := &godo.ListOptions{PerPage: 200}
+	for {
+		var droplets []godo.Droplet
+		var resp *godo.Response
+		err := dop.api.call(ctx, func(c *godo.Client) (r *godo.Response, err error) {
+			droplets, resp, err = c.Droplets.ListByTag(ctx, dop.tag, opt)
+			return resp, err
+		})
+		if err != nil {
+			return nil, err
+		}
+		for i := range droplets {
+			d := &droplets[i]
+			instances = append(instances, &doInstance{p: dop, id: instanceID(d), droplet: d, debug: dop.debug})
+		}
+
+		if resp.Links == nil || resp.Links.IsLastPage() {
+			break
+		}
+		page, err := resp.Links.CurrentPage()
+		if err != nil {
+			return nil, err
+		}
+		opt.Page = page + 1
+	}
+
+	return instances, nil
+}
+
+func dropletName(size, region, instanceID string) string {
+	return fmt.Sprintf("do-%s-%s-%s", size, region, instanceID)
+}
+
+// instanceID returns the ID a droplet has been provisioned with from its name.
+func instanceID(d *godo.Droplet) string {
+	region := ""
+	if d.Region != nil {
+		region = d.Region.Slug
+	}
+	return strings.TrimPrefix(d.Name, dropletName(d.SizeSlug, region, ""))
+}
+
+func (dop *doProvisioner) Size(ctx context.Context) (Size, error) {
+	opt := &godo.ListOptions{PerPage: 200}
+	for {
+		var sizes []godo.Size
+		var resp *godo.Response
+		err := dop.api.call(ctx, func(c *godo.Client) (r *godo.Response, err error) {
+			sizes, resp, err = c.Sizes.List(ctx, opt)
+			return resp, err
+		})
+		if err != nil {
+			return Size{}, err
+		}
+		for _, s := range sizes {
+			if s.Slug == dop.dropletSize {
+				return Size{Slug: s.Slug, MemoryMB: s.Memory, Vcpus: s.Vcpus, PriceHourly: s.PriceHourly}, nil
+			}
+		}
+
+		if resp.Links == nil || resp.Links.IsLastPage() {
 			break
 		}
+		page, err := resp.Links.CurrentPage()
+		if err != nil {
+			return Size{}, err
+		}
+		opt.Page = page + 1
 	}
 
-	if action != nil {
-		_ = util.WaitForActive(ctx, c, action.HREF)
-		dTmp, _, err := c.Droplets.Get(ctx, d.ID)
+	return Size{}, fmt.Errorf("unknown droplet size %s", dop.dropletSize)
+}
+
+var errNotActive = errors.New("droplet didn't become active")
+
+func (dop *doProvisioner) createDroplet(ctx context.Context, dcr *godo.DropletCreateRequest) (*godo.Droplet, error) {
+	var d *godo.Droplet
+	var resp *godo.Response
+	err := dop.api.create(ctx, func(c *godo.Client) (r *godo.Response, err error) {
+		d, resp, err = c.Droplets.Create(ctx, dcr)
+		return resp, err
+	}, func(ctx context.Context) (bool, error) {
+		found, err := dop.findDroplets(ctx, []string{dcr.Name})
+		if err != nil || len(found) == 0 {
+			return false, err
+		}
+		d, resp = &found[0], nil
+		return true, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	// A droplet found after a lost response has no create action to wait for
+	var actionID int
+	if resp != nil && resp.Links != nil {
+		for _, a := range resp.Links.Actions {
+			if a.Rel == "create" {
+				actionID = a.ID
+				break
+			}
+		}
+	}
+
+	if actionID != 0 || d.Status != "active" {
+		id := d.ID
+		var err error
+		if actionID == 0 {
+			var inactive []*godo.Droplet
+			if inactive, err = dop.waitForActive(ctx, []*godo.Droplet{d}); err == nil && len(inactive) > 0 {
+				err = fmt.Errorf("not active within %s", activeTimeout)
+			}
+		} else if err = dop.api.waitForAction(ctx, actionID); err == nil {
+			err = dop.api.call(ctx, func(c *godo.Client) (r *godo.Response, err error) {
+				d, r, err = c.Droplets.Get(ctx, id)
+				return r, err
+			})
+		}
 		if err != nil {
-			log.Println("Failed waiting for droplet to become active, destroying it:", d.Name)
-			if _, err := c.Droplets.Delete(context.TODO(), d.ID); err != nil {
-				log.Println("Failed to destroy droplet, please do so manually:", d.Name)
+			log.Println("Failed waiting for droplet to become active, destroying it:", dcr.Name)
+			if errDel := dop.deleteDroplet(id); errDel != nil {
+				log.Println("Failed to destroy droplet, please do so manually:", dcr.Name)
 			}
-			return nil, err
+			if ctx.Err() != nil {
+				return nil, err
+			}
+			return nil, fmt.Errorf("%w: %v", errNotActive, err)
 		}
-		d = dTmp
 	}
 
 	return d, nil
 }
 
+// findDroplets returns the droplets with the names, e.g. to find those
+// created by a request whose response got lost.
+func (dop *doProvisioner) findDroplets(ctx context.Context, names []string) ([]godo.Droplet, error) {
+	wanted := make(map[string]bool, len(names))
+	for _, n := range names {
+		wanted[n] = true
+	}
+
+	var found []godo.Droplet
+	opt := &godo.ListOptions{PerPage: 200}
+	for {
+		var droplets []godo.Droplet
+		var resp *godo.Response
+		err := dop.api.call(ctx, func(c *godo.Client) (r *godo.Response, err error) {
+			droplets, resp, err = c.Droplets.ListByTag(ctx, dop.tag, opt)
+			return resp, err
+		})
+		if err != nil {
+			return nil, err
+		}
+		for _, d := range droplets {
+			if wanted[d.Name] {
+				found = append(found, d)
+			}
+		}
+
+		if resp.Links == nil || resp.Links.IsLastPage() {
+			return found, nil
+		}
+		page, err := resp.Links.CurrentPage()
+		if err != nil {
+			return nil, err
+		}
+		opt.Page = page + 1
+	}
+}
+
+func (dop *doProvisioner) deleteDroplet(id int) error {
+	// The run's context might already be done when cleaning up
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Minute)
+	defer cancel()
+
+	return dop.api.call(ctx, func(c *godo.Client) (*godo.Response, error) {
+		return c.Droplets.Delete(ctx, id)
+	})
+}
+
 type doInstance struct {
-	apiToken string
-	droplet  *godo.Droplet
-	debug    bool
+	p       *doProvisioner
+	id      string
+	droplet *godo.Droplet
+	debug   bool
 }
 
 func (doi *doInstance) RunCmd(ctx context.Context, cmd string) error {
 	return runCmd(ctx, cmd, doi.droplet, doi.debug)
 }
 
+func (doi *doInstance) Output(ctx context.Context, cmd string) ([]byte, error) {
+	addr, err := doi.droplet.PublicIPv4()
+	if err != nil {
+		return nil, err
+	}
+
+	select {
+	case res := <-sshOutput(cmd, addr):
+		return res.out, res.err
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+func (doi *doInstance) WriteFile(ctx context.Context, path string, data []byte, mode os.FileMode) error {
+	addr, err := doi.droplet.PublicIPv4()
+	if err != nil {
+		return err
+	}
+
+	// The file is created with the final mode, so that it is never readable by others
+	cmd := fmt.Sprintf("umask 077 && mkdir -p %s && cat > %s && chmod %o %s", filepath.Dir(path), path, mode, path)
+	select {
+	case err = <-sshWrite(cmd, addr, data):
+		return err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
 func runCmd(ctx context.Context, cmd string, d *godo.Droplet, debug bool) error {
 	addr, err := d.PublicIPv4()
 	if err != nil {
@@ -125,16 +624,29 @@ func runCmd(ctx context.Context, cmd string, d *godo.Droplet, debug bool) error
 }
 
 func (doi *doInstance) Destroy() error {
-	client := godo.NewFromToken(doi.apiToken)
-	_, err := client.Droplets.Delete(context.TODO(), doi.droplet.ID)
-
-	return err
+	return doi.p.deleteDroplet(doi.droplet.ID)
 }
 
 func (doi *doInstance) String() string {
 	return doi.droplet.Name
 }
 
+func (doi *doInstance) ID() string {
+	return doi.id
+}
+
+func (doi *doInstance) Region() string {
+	if doi.droplet.Region == nil {
+		return ""
+	}
+	return doi.droplet.Region.Slug
+}
+
+func (doi *doInstance) Created() time.Time {
+	t, _ := time.Parse(time.RFC3339, doi.droplet.Created)
+	return t
+}
+
 const (
 	// ufw on the server side limits SSH connection attempts and blocks after 6 attempts within 30s.
 	// We therefore want to ensure no more attempts are made within a 30s period.
@@ -167,13 +679,14 @@ func isReachable(d *godo.Droplet) bool {
 }
 
 func sshRun(cmd, addr string, copyIO bool) <-chan error {
-	c := make(chan error)
+	c := make(chan error, 1)
 	go func() {
 		s, err := sshSession(addr)
 		if err != nil {
 			c <- err
 			return
 		}
+		defer s.Close()
 
 		if copyIO {
 			s.Stdout = os.Stdout
@@ -190,13 +703,57 @@ func sshRun(cmd, addr string, copyIO bool) <-chan error {
 	return c
 }
 
-func sshSession(addr string) (*ssh.Session, error) {
-	c, err := ssh.NewClient(defaultUser, addr+":"+defaultSSHPort)
-	if err != nil {
-		return nil, fmt.Errorf("can't establish client: %w", err)
-	}
+// sshWrite runs the command with data as its standard input.
+func sshWrite(cmd, addr string, data []byte) <-chan error {
+	c := make(chan error, 1)
+	go func() {
+		s, err := sshSession(addr)
+		if err != nil {
+			c <- err
+			return
+		}
+		defer s.Close()
+
+		s.Stdin = bytes.NewReader(data)
+		if err := s.Run(cmd); err != nil {
+			c <- fmt.Errorf("can't write file: %w", err)
+			return
+		}
+		c <- nil
+	}()
+
+	return c
+}
 
-	s, err := c.Session()
+type outputResult struct {
+	out []byte
+	err error
+}
+
+func sshOutput(cmd, addr string) <-chan outputResult {
+	c := make(chan outputResult, 1)
+	go func() {
+		s, err := sshSession(addr)
+		if err != nil {
+			c <- outputResult{nil, err}
+			return
+		}
+		defer s.Close()
+
+		out, err := s.Output(cmd)
+		if err != nil {
+			err = fmt.Errorf("can't run cmd: %w", err)
+		}
+		c <- outputResult{out, err}
+	}()
+
+	return c
+}
+
+// sshSession opens a session on a connection of its own, which the caller
+// has to close, since polling instances opens many of them.
+func sshSession(addr string) (*ssh.Session, error) {
+	s, err := ssh.Dial(defaultUser, addr+":"+defaultSSHPort)
 	if err != nil {
 		return nil, fmt.Errorf("can't create session: %w", err)
 	}
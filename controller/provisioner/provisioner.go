@@ -1,13 +1,45 @@
 package provisioner
 
-import "context"
+import (
+	"context"
+	"os"
+	"time"
+)
 
 type Provisioner interface {
-	Provision(ctx context.Context, instanceID string) (Instance, error)
+	// Provision creates an instance which runs the user data, e.g. a
+	// cloud-init document, on its first boot. The user data may be empty.
+	Provision(ctx context.Context, instanceID, userData string) (Instance, error)
+	// ProvisionMany provisions an instance for each of the IDs at once, all
+	// with the same user data. The instances are returned in the same order
+	// as the IDs.
+	ProvisionMany(ctx context.Context, instanceIDs []string, userData string) ([]Instance, error)
+	// List returns all instances that have been provisioned for load tests
+	// and not yet destroyed, including those of other runs.
+	List(ctx context.Context) ([]Instance, error)
+	// Size describes the instances created by the provisioner.
+	Size(ctx context.Context) (Size, error)
 }
 
 type Instance interface {
 	RunCmd(ctx context.Context, cmd string) error
+	// Output runs the command and returns its standard output.
+	Output(ctx context.Context, cmd string) ([]byte, error)
+	// WriteFile writes data to the file at path, which is only readable by
+	// its owner unless the mode says otherwise.
+	WriteFile(ctx context.Context, path string, data []byte, mode os.FileMode) error
 	Destroy() error
 	String() string
+	// ID returns the instanceID the instance has been provisioned with.
+	ID() string
+	// Region returns the region the instance has been created in.
+	Region() string
+	Created() time.Time
+}
+
+type Size struct {
+	Slug        string
+	MemoryMB    int
+	Vcpus       int
+	PriceHourly float64
 }
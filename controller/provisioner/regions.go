@@ -0,0 +1,92 @@
+package provisioner
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Regions weights the regions instances are spread across, e.g. 70% in
+// fra1 and 30% in ams3. The weights don't need to add up to anything.
+type Regions map[string]float64
+
+// Set parses a comma-separated list of regions with optional weights,
+// e.g. fra1:70,ams3:30. Regions without a weight have a weight of one.
+func (r *Regions) Set(val string) error {
+	regions := make(Regions)
+	for _, v := range strings.Split(val, ",") {
+		slug, weight := strings.TrimSpace(v), 1.0
+		if i := strings.Index(slug, ":"); i >= 0 {
+			w, err := strconv.ParseFloat(slug[i+1:], 64)
+			if err != nil {
+				return fmt.Errorf("failed to parse weight of region %s", slug[:i])
+			}
+			slug, weight = slug[:i], w
+		}
+		if slug == "" {
+			return fmt.Errorf("empty region in %q", val)
+		}
+		regions[slug] = weight
+	}
+
+	*r = regions
+	return nil
+}
+
+func (r Regions) String() string {
+	vals := make([]string, 0, len(r))
+	for _, slug := range r.Slugs() {
+		vals = append(vals, fmt.Sprintf("%s:%g", slug, r[slug]))
+	}
+	return strings.Join(vals, ",")
+}
+
+// Slugs returns the regions in alphabetical order.
+func (r Regions) Slugs() []string {
+	slugs := make([]string, 0, len(r))
+	for slug := range r {
+		slugs = append(slugs, slug)
+	}
+	sort.Strings(slugs)
+	return slugs
+}
+
+// Spread returns how many of n instances are created in each region.
+func (r Regions) Spread(n int) map[string]int {
+	s := newSpreader(r)
+	for i := 0; i < n; i++ {
+		s.next()
+	}
+	return s.counts
+}
+
+// spreader assigns instances to regions, so that the number of instances
+// in each region follows its weight throughout a run.
+type spreader struct {
+	sync.Mutex
+	regions Regions
+	slugs   []string
+	counts  map[string]int
+}
+
+func newSpreader(regions Regions) *spreader {
+	return &spreader{regions: regions, slugs: regions.Slugs(), counts: make(map[string]int)}
+}
+
+// next returns the region whose share of instances lags behind its weight the most.
+func (s *spreader) next() string {
+	s.Lock()
+	defer s.Unlock()
+
+	best, bestShare := "", 0.0
+	for _, slug := range s.slugs {
+		share := float64(s.counts[slug]+1) / s.regions[slug]
+		if best == "" || share < bestShare {
+			best, bestShare = slug, share
+		}
+	}
+	s.counts[best]++
+	return best
+}
@@ -0,0 +1,169 @@
+package provisioner
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/digitalocean/godo"
+)
+
+// Imager manages custom images which runner instances can boot from, in
+// order to skip pulling all container images on every instance.
+type Imager interface {
+	Provisioner
+	// Snapshot shuts the instance down and creates an image from it.
+	Snapshot(ctx context.Context, inst Instance, name string) (Image, error)
+	// Images lists all images whose name starts with the prefix, oldest first.
+	Images(ctx context.Context, prefix string) ([]Image, error)
+	// Transfer copies the image to another region.
+	Transfer(ctx context.Context, id, region string) error
+	DeleteImage(ctx context.Context, id string) error
+}
+
+type Image struct {
+	ID      string
+	Name    string
+	Created time.Time
+	Regions []string
+	SizeGB  float64
+}
+
+// defaultImage is the stock image used if no custom image is configured.
+const defaultImage = "docker-20-04"
+
+// NewDOImager returns an Imager which builds upon the stock docker image.
+// Its droplets are tagged apart from runners.
+func NewDOImager(apiToken, region, dropletSize string, debug bool) Imager {
+	dop := NewDO(apiToken, Regions{region: 1}, dropletSize, "", debug).(*doProvisioner)
+	dop.tag = buildTag
+	return dop
+}
+
+// dropletImage returns the image to create droplets from. Numeric values
+// refer to custom images by their ID, all others to public images by slug.
+func dropletImage(image string) godo.DropletCreateImage {
+	if image == "" {
+		image = defaultImage
+	}
+	if id, err := strconv.Atoi(image); err == nil {
+		return godo.DropletCreateImage{ID: id}
+	}
+	return godo.DropletCreateImage{Slug: image}
+}
+
+func (dop *doProvisioner) Snapshot(ctx context.Context, inst Instance, name string) (Image, error) {
+	doi, ok := inst.(*doInstance)
+	if !ok {
+		return Image{}, errors.New("not a droplet")
+	}
+	id := doi.droplet.ID
+
+	log.Println("Shutting down", doi)
+	var action *godo.Action
+	err := dop.api.call(ctx, func(c *godo.Client) (r *godo.Response, err error) {
+		action, r, err = c.DropletActions.Shutdown(ctx, id)
+		return r, err
+	})
+	if err == nil {
+		err = dop.api.waitForAction(ctx, action.ID)
+	}
+	if err != nil {
+		return Image{}, fmt.Errorf("failed to shut down droplet: %w", err)
+	}
+
+	log.Println("Creating snapshot", name, "of", doi)
+	err = dop.api.call(ctx, func(c *godo.Client) (r *godo.Response, err error) {
+		action, r, err = c.DropletActions.Snapshot(ctx, id, name)
+		return r, err
+	})
+	if err == nil {
+		err = dop.api.waitForAction(ctx, action.ID)
+	}
+	if err != nil {
+		return Image{}, fmt.Errorf("failed to snapshot droplet: %w", err)
+	}
+
+	images, err := dop.Images(ctx, name)
+	if err != nil {
+		return Image{}, err
+	}
+	for _, img := range images {
+		if img.Name == name {
+			return img, nil
+		}
+	}
+
+	return Image{}, fmt.Errorf("snapshot %s not found after creation", name)
+}
+
+func (dop *doProvisioner) Images(ctx context.Context, prefix string) ([]Image, error) {
+	var images []Image
+	opt := &godo.ListOptions{PerPage: 200}
+	for {
+		var snapshots []godo.Snapshot
+		var resp *godo.Response
+		err := dop.api.call(ctx, func(c *godo.Client) (r *godo.Response, err error) {
+			snapshots, resp, err = c.Snapshots.ListDroplet(ctx, opt)
+			return resp, err
+		})
+		if err != nil {
+			return nil, err
+		}
+		for _, s := range snapshots {
+			if !strings.HasPrefix(s.Name, prefix) {
+				continue
+			}
+			created, _ := time.Parse(time.RFC3339, s.Created)
+			images = append(images, Image{ID: s.ID, Name: s.Name, Created: created, Regions: s.Regions, SizeGB: s.SizeGigaBytes})
+		}
+
+		if resp.Links == nil || resp.Links.IsLastPage() {
+			break
+		}
+		page, err := resp.Links.CurrentPage()
+		if err != nil {
+			return nil, err
+		}
+		opt.Page = page + 1
+	}
+
+	sort.Slice(images, func(i, j int) bool {
+		return images[i].Created.Before(images[j].Created)
+	})
+
+	return images, nil
+}
+
+func (dop *doProvisioner) Transfer(ctx context.Context, id, region string) error {
+	imageID, err := strconv.Atoi(id)
+	if err != nil {
+		return fmt.Errorf("invalid image ID %s: %w", id, err)
+	}
+
+	log.Println("Transferring image", id, "to", region)
+	var action *godo.Action
+	err = dop.api.call(ctx, func(c *godo.Client) (r *godo.Response, err error) {
+		action, r, err = c.ImageActions.Transfer(ctx, imageID, &godo.ActionRequest{"type": "transfer", "region": region})
+		return r, err
+	})
+	if err == nil {
+		err = dop.api.waitForAction(ctx, action.ID)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to transfer image to %s: %w", region, err)
+	}
+
+	return nil
+}
+
+func (dop *doProvisioner) DeleteImage(ctx context.Context, id string) error {
+	return dop.api.call(ctx, func(c *godo.Client) (*godo.Response, error) {
+		return c.Snapshots.Delete(ctx, id)
+	})
+}
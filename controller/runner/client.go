@@ -5,22 +5,29 @@
 package runner
 
 import (
+	"bytes"
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
+	"io/ioutil"
 	"log"
+	"net"
+	"net/http"
 	"os"
 	"os/exec"
+	"strings"
+	"sync"
 	"sync/atomic"
 	"time"
 
 	"github.com/DerGut/load-tests/accounts"
 	"github.com/DerGut/load-tests/controller/provisioner"
+	"github.com/DerGut/load-tests/secrets"
 )
 
 const (
-	agentImage          = "datadog/agent:latest"
-	runnerImage         = "jsteinmann/load-tests-runner:latest"
 	screenshotPathImage = "/home/pwuser/runner/errors"
 	screenshotPathHost  = "/root/errors"
 )
@@ -28,38 +35,92 @@ const (
 var runnerCounter int32 = 0
 
 type Client interface {
-	Start(context.Context, *Step, provisioner.Provisioner) error
+	// Start deploys the runner to the instance, which is nil for local runners.
+	Start(context.Context, *Step, provisioner.Instance) error
+	// UserData returns what the runner's instance should be provisioned
+	// with. It is the same for all runners of a run.
+	UserData() (string, error)
+	// StartContainer starts an additional runner container with the classes
+	// on a runner which has already been started.
+	StartContainer(ctx context.Context, container int, accs []accounts.Classroom) error
+	// AddClasses starts the classes in a runner container which is already running.
+	AddClasses(ctx context.Context, container int, accs []accounts.Classroom) error
+	// RemoveClasses stops the classes in a running runner container and
+	// returns once all of their users have stopped.
+	RemoveClasses(ctx context.Context, container int, accs []accounts.Classroom) error
+	// Submitted returns what the pupils of all containers have submitted so far by their username.
+	Submitted(ctx context.Context) (map[string]Submissions, error)
 	Stop() error
+	// Region returns the region the runner has been deployed to, it is
+	// empty for local runners.
+	Region() string
+	fmt.Stringer
 }
 
-func NewRemote(runID, ddApiKey string) Client {
+// NewRemote returns a client for a runner instance which hosts the given
+// number of runner containers.
+func NewRemote(runID, ddApiKey string, images Images, containers int) Client {
 	currentCounter := atomic.AddInt32(&runnerCounter, 1)
 	return &RemoteClient{
-		runID:    runID,
-		name:     fmt.Sprintf("%s-%d", runID, currentCounter),
-		ddApiKey: ddApiKey,
+		runID:      runID,
+		name:       fmt.Sprintf("%s-%d", runID, currentCounter),
+		ddApiKey:   ddApiKey,
+		images:     images,
+		containers: containers,
 	}
 }
 
+// FromInstance returns the client of a runner which has already been
+// deployed to the instance, e.g. by another invocation of loadctl.
+func FromInstance(inst provisioner.Instance) *RemoteClient {
+	return &RemoteClient{
+		runID:    RunID(inst.ID()),
+		name:     inst.ID(),
+		instance: inst,
+	}
+}
+
+// RunID returns the ID of the run a remote runner belongs to given its name.
+func RunID(name string) string {
+	i := strings.LastIndex(name, "-")
+	if i < 0 {
+		return name
+	}
+	return name[:i]
+}
+
 type RemoteClient struct {
-	runID    string
-	name     string
-	ddApiKey string
-	instance provisioner.Instance
+	runID      string
+	name       string
+	ddApiKey   string
+	images     Images
+	containers int
+	instance   provisioner.Instance
+
+	mu sync.Mutex
+	// started records the containers which have been started, only those
+	// serve their control channel.
+	started map[int]bool
 }
 
 type Step struct {
-	Url      string
-	Accounts []accounts.Classroom
+	Url string
+	// Containers holds the classes of each runner container on the instance.
+	// Containers without classes aren't started.
+	Containers [][]accounts.Classroom
 }
 
-func (rc *RemoteClient) Start(ctx context.Context, step *Step, p provisioner.Provisioner) error {
-	inst, err := p.Provision(ctx, rc.name)
-	if err != nil {
-		return fmt.Errorf("failed to provision instance: %w", err)
+// Accounts returns the classes of all containers.
+func (s *Step) Accounts() []accounts.Classroom {
+	var accs []accounts.Classroom
+	for _, c := range s.Containers {
+		accs = append(accs, c...)
 	}
+	return accs
+}
 
-	err = rc.deploy(ctx, inst, step)
+func (rc *RemoteClient) Start(ctx context.Context, step *Step, inst provisioner.Instance) error {
+	err := rc.deploy(ctx, inst, step)
 	if err != nil {
 		inst.Destroy()
 		return fmt.Errorf("failed runner deployment: %w", err)
@@ -72,54 +133,203 @@ func (rc *RemoteClient) Start(ctx context.Context, step *Step, p provisioner.Pro
 	return nil
 }
 
+// UserData returns the cloud-init document which bootstraps the instance. It
+// starts the agent right away and the runner once its step has been uploaded.
+func (rc *RemoteClient) UserData() (string, error) {
+	return userData(rc.runID, rc.images, rc.containers)
+}
+
+// deploy uploads the step to the instance and waits for the bootstrap to start the runner.
 func (rc *RemoteClient) deploy(ctx context.Context, inst provisioner.Instance, step *Step) error {
-	select {
-	case <-ctx.Done():
-		return ctx.Err()
-	default:
-		if err := inst.RunCmd(ctx, "docker network create load-tests"); err != nil {
-			return fmt.Errorf("failed to create docker network on host %s: %w", inst, err)
+	if len(step.Containers) > rc.containers {
+		return fmt.Errorf("step for %d containers, but the runner only hosts %d", len(step.Containers), rc.containers)
+	}
+
+	log.Println("Deploying runner to", inst)
+	agentEnv := fmt.Sprintf("DD_API_KEY=%s\n", rc.ddApiKey)
+	if err := inst.WriteFile(ctx, agentEnvFile, []byte(agentEnv), 0o600); err != nil {
+		return fmt.Errorf("failed to upload agent secrets to host %s: %w", inst, err)
+	}
+	for i, accs := range step.Containers {
+		if len(accs) == 0 {
+			continue
+		}
+		accountsJson, err := json.Marshal(accs)
+		if err != nil {
+			return err
+		}
+		// The runner script hands the file to the user of the runner container
+		if err := inst.WriteFile(ctx, accountsFile(i), accountsJson, 0o600); err != nil {
+			return fmt.Errorf("failed to upload accounts to host %s: %w", inst, err)
 		}
+		// The bootstrap starts every container whose accounts have been uploaded
+		rc.markStarted(i)
 	}
+	env := fmt.Sprintf("URL=%s\n", step.Url)
+	if err := inst.WriteFile(ctx, stepEnvFile, []byte(env), 0o600); err != nil {
+		return fmt.Errorf("failed to upload step to host %s: %w", inst, err)
+	}
+
+	return waitForReady(ctx, inst)
+}
+
+func (rc *RemoteClient) StartContainer(ctx context.Context, container int, accs []accounts.Classroom) error {
+	if container >= rc.containers {
+		return fmt.Errorf("runner %s only hosts %d containers", rc, rc.containers)
+	}
+
+	accountsJson, err := json.Marshal(accs)
+	if err != nil {
+		return err
+	}
+	if err := rc.instance.WriteFile(ctx, accountsFile(container), accountsJson, 0o600); err != nil {
+		return fmt.Errorf("failed to upload accounts to host %s: %w", rc.instance, err)
+	}
+
+	log.Println("Starting", containerName(container), "on", rc.instance)
+	cmd := fmt.Sprintf("%s start %d && %s wait %d", runnerPath, container, runnerPath, container)
+	out, err := rc.instance.Output(ctx, cmd)
+	if err != nil {
+		return fmt.Errorf("failed to start %s on host %s: %s %w", containerName(container), rc.instance, strings.TrimSpace(string(out)), err)
+	}
+	rc.markStarted(container)
+
+	return nil
+}
 
-	log.Println("Deploying agent to", inst)
-	cmd := agentCmd(rc.ddApiKey, rc.runID)
-	select {
-	case <-ctx.Done():
-		return ctx.Err()
-	default:
-		if err := inst.RunCmd(ctx, cmd); err != nil {
-			return fmt.Errorf("failed to start statsD agent on host %s: %w", inst, err)
+func (rc *RemoteClient) markStarted(container int) {
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+	if rc.started == nil {
+		rc.started = make(map[int]bool)
+	}
+	rc.started[container] = true
+}
+
+// startedContainers returns the started containers in order.
+func (rc *RemoteClient) startedContainers() []int {
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+	var started []int
+	for i := 0; i < rc.containers; i++ {
+		if rc.started[i] {
+			started = append(started, i)
 		}
 	}
+	return started
+}
+
+func (rc *RemoteClient) AddClasses(ctx context.Context, container int, accs []accounts.Classroom) error {
+	log.Println("Adding", len(accs), "classes to", containerName(container), "on", rc.instance)
+	return rc.control(ctx, container, "/classes", accs)
+}
+
+func (rc *RemoteClient) RemoveClasses(ctx context.Context, container int, accs []accounts.Classroom) error {
+	log.Println("Removing", len(accs), "classes from", containerName(container), "on", rc.instance)
+	return rc.control(ctx, container, "/classes/remove", teachers(accs))
+}
 
-	// Let agent start up first to catch all metrics
-	select {
-	case <-ctx.Done():
-		return ctx.Err()
-	case <-time.After(1 * time.Minute):
+// Submissions counts what a pupil has submitted.
+type Submissions struct {
+	Exercises  int `json:"exercises"`
+	TaskSeries int `json:"taskseries"`
+}
+
+// Submitted collects the submissions of all started containers, the others
+// never had any classes.
+func (rc *RemoteClient) Submitted(ctx context.Context) (map[string]Submissions, error) {
+	all := make(map[string]Submissions)
+	for _, i := range rc.startedContainers() {
+		cmd := fmt.Sprintf("curl --silent --show-error --fail http://127.0.0.1:%d/submitted", controlPortHost(i))
+		out, err := rc.instance.Output(ctx, cmd)
+		if err != nil {
+			return nil, fmt.Errorf("control request /submitted to %s on host %s failed: %s %w", containerName(i), rc.instance, strings.TrimSpace(string(out)), err)
+		}
+		if err := json.Unmarshal(out, &all); err != nil {
+			return nil, fmt.Errorf("malformed submissions of %s on host %s: %w", containerName(i), rc.instance, err)
+		}
 	}
+	return all, nil
+}
+
+var requestCounter int32 = 0
 
-	cmd = fmt.Sprintf("mkdir -p %s && chmod 777 %s", screenshotPathHost, screenshotPathHost)
-	if err := inst.RunCmd(ctx, cmd); err != nil {
-		log.Println("Failed creating error dir")
+// control posts the request to the control channel of the runner container.
+// The request is uploaded to the instance first, so that credentials don't
+// show up in any command line.
+func (rc *RemoteClient) control(ctx context.Context, container int, path string, request interface{}) error {
+	if container >= rc.containers {
+		return fmt.Errorf("runner %s only hosts %d containers", rc, rc.containers)
 	}
 
-	accountsJson, err := json.Marshal(step.Accounts)
+	body, err := json.Marshal(request)
 	if err != nil {
 		return err
 	}
+	file := fmt.Sprintf("%s/%d/request-%d.json", stepDir, container, atomic.AddInt32(&requestCounter, 1))
+	if err := rc.instance.WriteFile(ctx, file, body, 0o600); err != nil {
+		return fmt.Errorf("failed to upload request to host %s: %w", rc.instance, err)
+	}
 
-	log.Println("Deploying runner to", inst)
-	cmd = runnerCmd(rc.runID, step.Url, string(accountsJson))
-	if err := inst.RunCmd(ctx, cmd); err != nil {
-		return fmt.Errorf("failed to start runner on host %s: %w", inst, err)
+	cmd := fmt.Sprintf("curl --silent --show-error --fail -H 'Content-Type: application/json' --data-binary @%s http://127.0.0.1:%d%s; status=$?; rm -f %s; exit $status",
+		file, controlPortHost(container), path, file)
+	out, err := rc.instance.Output(ctx, cmd)
+	if err != nil {
+		return fmt.Errorf("control request %s to %s on host %s failed: %s %w", path, containerName(container), rc.instance, strings.TrimSpace(string(out)), err)
 	}
 
 	return nil
 }
 
-func agentCmd(ddApiKey, runID string) string {
+// teachers returns the emails of the teachers of the classes, which identify
+// the classes on the control channel.
+func teachers(accs []accounts.Classroom) []string {
+	emails := make([]string, len(accs))
+	for i, c := range accs {
+		emails[i] = c.Teacher.Email
+	}
+	return emails
+}
+
+const (
+	// readyTimeout covers pulling all images and waiting for all probes. The
+	// probes time out on their own, this only catches a stuck bootstrap.
+	readyTimeout = 30 * time.Minute
+	readyPoll    = 10 * time.Second
+)
+
+// waitForReady polls the instance for the markers written by the bootstrap script.
+func waitForReady(ctx context.Context, inst provisioner.Instance) error {
+	ctx, cancel := context.WithTimeout(ctx, readyTimeout)
+	defer cancel()
+
+	cmd := fmt.Sprintf("if [ -f %s ]; then echo ready; elif [ -f %s ]; then echo failed; cat %s; fi", readyMarker, failedMarker, failedMarker)
+	for {
+		out, err := inst.Output(ctx, cmd)
+		state, reason := splitFirstLine(string(out))
+		switch state {
+		case "ready":
+			return nil
+		case "failed":
+			logs, _ := inst.Output(ctx, "tail -n 20 "+bootstrapLog)
+			return fmt.Errorf("%s on host %s, bootstrap log:\n%s", reason, inst, logs)
+		}
+		if err != nil && ctx.Err() == nil {
+			log.Println("Couldn't check readiness of", inst, "-", err)
+		}
+
+		select {
+		case <-time.After(readyPoll):
+		case <-ctx.Done():
+			if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+				return fmt.Errorf("host %s not ready after %s", inst, readyTimeout)
+			}
+			return ctx.Err()
+		}
+	}
+}
+
+func agentCmd(runID, image string) string {
 	return fmt.Sprintf(`docker run \
 	--detach \
 	--name dd-agent \
@@ -130,8 +340,8 @@ func agentCmd(ddApiKey, runID string) string {
 	--volume /sys/fs/cgroup/:/host/sys/fs/cgroup:ro \
 	--volume /etc/passwd:/etc/passwd:ro \
 	--publish 8125:8125/udp \
-	--env DD_API_KEY=%s \
-	--env DD_TAGS=runId:%s \
+	--env-file %s \
+	--env "DD_TAGS=runId:%s region:$region" \
 	--env DD_ENV=load-tests \
 	--env DD_DOGSTATSD_NON_LOCAL_TRAFFIC=true \
 	--env DD_APM_ENABLED=true \
@@ -140,29 +350,56 @@ func agentCmd(ddApiKey, runID string) string {
 	--env DD_CONTAINER_EXCLUDE="name:dd-agent" \
 	--env DD_APM_NON_LOCAL_TRAFFIC=true \
 	--env DD_PROCESS_AGENT_ENABLED=true \
-	%s`, ddApiKey, runID, agentImage)
+	%s`, agentEnvFile, runID, image)
 }
 
-func runnerCmd(runID, url, accounts string) string {
+func splitFirstLine(s string) (string, string) {
+	parts := strings.SplitN(strings.TrimSpace(s), "\n", 2)
+	if len(parts) < 2 {
+		return parts[0], ""
+	}
+	return parts[0], strings.TrimSpace(parts[1])
+}
+
+// runnerCmd returns the command which starts a runner container with the
+// $cpus, $memory and $heap of runnerTmpl. Its control channel is published
+// to controlPortHost on the loopback interface only.
+func runnerCmd(runID, image, name, accountsFile, controlPortHost string) string {
 	return fmt.Sprintf(`docker run \
 	--detach \
-	--name runner \
+	--name %s \
 	--network load-tests \
 	--ipc=host \
+	--cpus $cpus \
+	--memory ${memory}m \
 	--volume %s:%s \
-	--env NODE_OPTIONS=--max-old-space-size=4096 \
+	--volume %s:%s:ro \
+	--env-file %s \
+	--env NODE_OPTIONS=--max-old-space-size=$heap \
 	--env NODE_ENV=production \
 	--env DD_AGENT_HOST=dd-agent \
 	--env DD_TRACE_AGENT_HOSTNAME=dd-agent \
 	--env DD_RUNTIME_METRICS_ENABLED=true \
-	--env DD_TAGS=runId:%s \
+	--env "DD_TAGS=runId:%s,region:$region" \
 	--env RUN_ID=%s \
-	--env URL=%s \
+	--env REGION=$region \
 	--env SCREENSHOT_PATH=%s \
-	--env 'ACCOUNTS=%s' \
-	%s`, screenshotPathHost, screenshotPathImage, runID, runID, url, screenshotPathImage, accounts, runnerImage)
+	--env ACCOUNTS=%s \
+	--env READY_FILE=%s \
+	--env CONTROL_PORT=%d \
+	--publish 127.0.0.1:%s:%d \
+	%s`, name, screenshotPathHost, screenshotPathImage, accountsFile, accountsPathImage, stepEnvFile, runID, runID, screenshotPathImage, accountsPathImage, readyFileImage, controlPort, controlPortHost, controlPort, image)
+}
+
+// PullCmd returns the command which pulls all images a runner instance
+// needs, e.g. to bake them into a snapshot.
+func PullCmd(images Images) string {
+	return fmt.Sprintf("docker pull %s && docker pull %s", images.Agent, images.Runner)
 }
 
+// runnerContainers lists the names of all runner containers on the instance.
+const runnerContainers = `docker ps --all --filter name=^runner- --format "{{.Names}}"`
+
 func (rc *RemoteClient) Stop() error {
 	// Graceful shutdown allows runner to update metrics that track numbers of runners, VUs, etc.
 	// TODO: the exercise think time is probably the limiting factor here. If we really want
@@ -171,7 +408,7 @@ func (rc *RemoteClient) Stop() error {
 	timeout := 5 * 60 // in seconds
 	ctx, cancel := context.WithTimeout(context.Background(), time.Duration(timeout+30)*time.Second)
 	defer cancel()
-	err := rc.instance.RunCmd(ctx, fmt.Sprintf("docker stop --time %d runner", timeout))
+	err := rc.instance.RunCmd(ctx, fmt.Sprintf("%s | xargs --no-run-if-empty docker stop --time %d", runnerContainers, timeout))
 	if err != nil {
 		log.Println("Graceful shutdown failed")
 	}
@@ -180,6 +417,25 @@ func (rc *RemoteClient) Stop() error {
 	return rc.instance.Destroy()
 }
 
+// Logs writes the logs of all runner containers to w.
+func (rc *RemoteClient) Logs(ctx context.Context, w io.Writer) error {
+	cmd := fmt.Sprintf(`for c in $(%s); do echo "==> $c <=="; docker logs $c 2>&1; done`, runnerContainers)
+	out, err := rc.instance.Output(ctx, cmd)
+	if err != nil {
+		return err
+	}
+
+	_, err = w.Write(out)
+	return err
+}
+
+func (rc *RemoteClient) Region() string {
+	if rc.instance == nil {
+		return ""
+	}
+	return rc.instance.Region()
+}
+
 func (rc *RemoteClient) String() string {
 	return rc.name
 }
@@ -193,37 +449,140 @@ const (
 )
 
 type LocalClient struct {
-	proc *os.Process
+	proc        *os.Process
+	controlPort int
+	// accountsFile keeps the credentials off the command line, only the
+	// current user can read it.
+	accountsFile string
 }
 
-func (lc *LocalClient) Start(_ctx context.Context, s *Step, _ provisioner.Provisioner) error {
-	accountsJson, err := json.Marshal(s.Accounts)
+func (lc *LocalClient) Start(_ctx context.Context, s *Step, _ provisioner.Instance) error {
+	accountsJson, err := json.Marshal(s.Accounts())
 	if err != nil {
 		return err
 	}
+	f, err := ioutil.TempFile("", "accounts-*.json")
+	if err != nil {
+		return err
+	}
+	lc.accountsFile = f.Name()
+	_, err = f.Write(accountsJson)
+	if cErr := f.Close(); err == nil {
+		err = cErr
+	}
+	if err != nil {
+		os.Remove(lc.accountsFile)
+		return fmt.Errorf("failed to write accounts file: %w", err)
+	}
 
 	cmd := exec.Command(
 		"node",
 		runnerFile,
 		"local-run",
 		s.Url,
-		string(accountsJson),
+		lc.accountsFile,
 	)
-	cmd.Stdout = os.Stdout
-	cmd.Stderr = os.Stderr
+	cmd.Stdout = secrets.Writer(os.Stdout)
+	cmd.Stderr = secrets.Writer(os.Stderr)
+	port, err := freePort()
+	if err != nil {
+		return fmt.Errorf("couldn't find a port for the control channel: %w", err)
+	}
 	cmd.Env = []string{
 		"NODE_OPTIONS=--max-old-space-size=4096", // v8 heap memory in MB
+		fmt.Sprintf("CONTROL_PORT=%d", port),
 	}
 
 	if err := cmd.Start(); err != nil {
+		os.Remove(lc.accountsFile)
 		return err
 	}
 
 	lc.proc = cmd.Process
+	lc.controlPort = port
+	return nil
+}
+
+// freePort returns a port on localhost which is currently unused.
+func freePort() (int, error) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return 0, err
+	}
+	defer l.Close()
+	return l.Addr().(*net.TCPAddr).Port, nil
+}
+
+func (lc *LocalClient) UserData() (string, error) {
+	return "", nil
+}
+
+func (lc *LocalClient) StartContainer(context.Context, int, []accounts.Classroom) error {
+	return errors.New("local runners don't support additional containers")
+}
+
+func (lc *LocalClient) AddClasses(ctx context.Context, container int, accs []accounts.Classroom) error {
+	return lc.control(ctx, container, "/classes", accs)
+}
+
+func (lc *LocalClient) RemoveClasses(ctx context.Context, container int, accs []accounts.Classroom) error {
+	return lc.control(ctx, container, "/classes/remove", teachers(accs))
+}
+
+func (lc *LocalClient) Submitted(ctx context.Context) (map[string]Submissions, error) {
+	url := fmt.Sprintf("http://127.0.0.1:%d/submitted", lc.controlPort)
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("control request /submitted failed: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("control request /submitted failed with %s", resp.Status)
+	}
+
+	var all map[string]Submissions
+	if err := json.NewDecoder(resp.Body).Decode(&all); err != nil {
+		return nil, fmt.Errorf("malformed submissions: %w", err)
+	}
+	return all, nil
+}
+
+func (lc *LocalClient) control(ctx context.Context, container int, path string, request interface{}) error {
+	if container != 0 {
+		return errors.New("local runners only have a single container")
+	}
+
+	body, err := json.Marshal(request)
+	if err != nil {
+		return err
+	}
+	url := fmt.Sprintf("http://127.0.0.1:%d%s", lc.controlPort, path)
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("control request %s failed: %w", path, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		msg, _ := ioutil.ReadAll(resp.Body)
+		return fmt.Errorf("control request %s failed with %s: %s", path, resp.Status, strings.TrimSpace(string(msg)))
+	}
+
 	return nil
 }
 
 func (lc *LocalClient) Stop() error {
+	defer os.Remove(lc.accountsFile)
 	if err := lc.proc.Signal(os.Interrupt); err != nil {
 		return err
 	}
@@ -231,6 +590,10 @@ func (lc *LocalClient) Stop() error {
 	return err
 }
 
+func (lc *LocalClient) Region() string {
+	return ""
+}
+
 func (lc *LocalClient) String() string {
 	return "local"
 }
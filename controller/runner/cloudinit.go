@@ -0,0 +1,232 @@
+package runner
+
+import (
+	"bytes"
+	"fmt"
+	"text/template"
+
+	"github.com/DerGut/load-tests/controller/provisioner"
+	"gopkg.in/yaml.v2"
+)
+
+const (
+	// stepDir is where the controller uploads the runner's configuration to.
+	stepDir = "/root/runner"
+	// stepEnvFile holds the env vars of the runner container. Bootstrapping
+	// waits for it, so it is uploaded last.
+	stepEnvFile = stepDir + "/step.env"
+	// agentEnvFile holds the secrets of the agent container. The controller
+	// uploads it, so that they neither end up in the user data, which every
+	// container can read from the metadata service, nor on a command line.
+	agentEnvFile = stepDir + "/agent.env"
+	// accountsPathImage is where the accounts file is mounted into the runner container.
+	accountsPathImage = "/home/pwuser/runner/accounts.json"
+
+	markerDir = "/var/lib/load-tests"
+	// readyMarker is written once the agent and runner are ready.
+	readyMarker = markerDir + "/ready"
+	// failedMarker is written if the bootstrap script fails, it holds the reason.
+	failedMarker  = markerDir + "/failed"
+	bootstrapPath = "/usr/local/bin/load-tests-bootstrap"
+	// runnerPath starts and waits for individual runner containers.
+	runnerPath   = "/usr/local/bin/load-tests-runner"
+	bootstrapLog = "/var/log/load-tests-bootstrap.log"
+
+	// regionCmd looks up the region of the instance, which the agent and
+	// runner containers tag their metrics with.
+	regionCmd = "region=$(curl --silent --fail http://169.254.169.254/metadata/v1/region || true)"
+
+	// controlPort is where the runner container serves its control channel.
+	controlPort = 8100
+)
+
+// controlPortHost returns the port on the host the control channel of the
+// i-th runner container is published to.
+func controlPortHost(i int) int {
+	return controlPort + i
+}
+
+// cloudConfig is the subset of the cloud-init config used to bootstrap runners.
+type cloudConfig struct {
+	WriteFiles []cloudFile `yaml:"write_files"`
+	RunCmd     []string    `yaml:"runcmd"`
+}
+
+type cloudFile struct {
+	Path        string `yaml:"path"`
+	Permissions string `yaml:"permissions"`
+	Content     string `yaml:"content"`
+}
+
+var bootstrapTmpl = template.Must(template.New("bootstrap").Parse(`#!/bin/sh
+set -e
+mkdir -p {{.MarkerDir}}
+trap '[ -f {{.ReadyMarker}} ] || [ -f {{.FailedMarker}} ] || echo "bootstrap failed" > {{.FailedMarker}}' EXIT
+
+fail() {
+	echo "$1" > {{.FailedMarker}}
+	exit 1
+}
+
+{{.WaitFunc}}
+
+{{.RegionCmd}}
+until docker info > /dev/null 2>&1; do sleep 1; done
+docker network create load-tests
+
+{{.PullAgent}}
+{{.PullRunner}}
+until [ -f {{.AgentEnvFile}} ]; do sleep 2; done
+{{.AgentCmd}}
+
+# Let agent start up first to catch all metrics
+out=$({{.WaitAgent}}) || fail "$out"
+
+mkdir -p {{.ScreenshotPath}} && chmod 777 {{.ScreenshotPath}}
+
+# The controller uploads the runner's configuration once it can reach the instance
+until [ -f {{.StepEnvFile}} ]; do sleep 2; done
+{{range .Containers}}
+if [ -f {{.AccountsFile}} ]; then {{$.RunnerPath}} start {{.Index}}; fi
+{{- end}}
+{{range .Containers}}
+if [ -f {{.AccountsFile}} ]; then out=$({{$.RunnerPath}} wait {{.Index}}) || fail "$out"; fi
+{{- end}}
+
+touch {{.ReadyMarker}}
+`))
+
+// runnerTmpl starts a runner container or waits for it. The bootstrap script
+// uses it for all containers with accounts, the controller for those it adds
+// to a running instance later on.
+var runnerTmpl = template.Must(template.New("runner").Parse(`#!/bin/sh
+set -e
+
+{{.WaitFunc}}
+
+name=runner-$2
+accounts={{.StepDir}}/$2/accounts.json
+port=$(({{.ControlPort}} + $2))
+{{.RegionCmd}}
+case $1 in
+start)
+	# Each runner container gets an equal share of what the agent leaves over
+	cpus=$(awk "BEGIN { print ($(nproc) - {{.AgentCpus}}) / {{.Containers}} }")
+	memory=$(awk '/MemTotal/ { print int(($2 / 1024 - {{.AgentMemoryMB}}) / {{.Containers}}) }' /proc/meminfo)
+	# Node's heap stays within the container's share, the rest is left to the browsers
+	heap=$((memory * {{.NodeHeapPercent}} / 100))
+	if [ $heap -gt {{.MaxNodeHeapMB}} ]; then heap={{.MaxNodeHeapMB}}; fi
+	# Only the user the runner container runs as may read its accounts
+	chown "$(docker run --rm --entrypoint id {{.RunnerImage}} -u)" $accounts
+	{{.RunnerCmd}}
+	;;
+wait)
+	{{.WaitRunner}}
+	;;
+*)
+	echo "usage: $0 start|wait <container>" >&2
+	exit 64
+	;;
+esac
+`))
+
+const (
+	// agentCpus and agentMemoryMB are left over for the agent and the OS when
+	// sharing an instance between runner containers.
+	agentCpus     = 0.25
+	agentMemoryMB = 512
+
+	// nodeHeapPercent of a runner container's memory, at most maxNodeHeapMB,
+	// limit the heap of its Node process, so that it fails within Node
+	// instead of the container being OOM-killed.
+	nodeHeapPercent = 50
+	maxNodeHeapMB   = 4096
+)
+
+// ContainerShare returns the memory and CPUs each of the runner containers
+// on an instance of the given size is limited to.
+func ContainerShare(size provisioner.Size, containers int) (memoryMB, cpus float64) {
+	memoryMB = (float64(size.MemoryMB) - agentMemoryMB) / float64(containers)
+	cpus = (float64(size.Vcpus) - agentCpus) / float64(containers)
+	return memoryMB, cpus
+}
+
+// bootstrapContainer is a runner container started by the bootstrap script
+// if its accounts have been uploaded.
+type bootstrapContainer struct {
+	Index        int
+	AccountsFile string
+}
+
+// userData returns the cloud-init document which deploys the agent and the
+// runner containers.
+func userData(runID string, images Images, containers int) (string, error) {
+	var runnerScript bytes.Buffer
+	err := runnerTmpl.Execute(&runnerScript, map[string]interface{}{
+		"WaitFunc":        waitFunc,
+		"StepDir":         stepDir,
+		"AgentCpus":       agentCpus,
+		"AgentMemoryMB":   agentMemoryMB,
+		"NodeHeapPercent": nodeHeapPercent,
+		"MaxNodeHeapMB":   maxNodeHeapMB,
+		"Containers":      containers,
+		"ControlPort":     controlPort,
+		"RegionCmd":       regionCmd,
+		"RunnerImage":     images.Runner,
+		"RunnerCmd":       runnerCmd(runID, images.Runner, "$name", "$accounts", "$port"),
+		"WaitRunner":      runnerProbe("$name").waitCmd(),
+	})
+	if err != nil {
+		return "", err
+	}
+
+	bootstrapContainers := make([]bootstrapContainer, containers)
+	for i := range bootstrapContainers {
+		bootstrapContainers[i] = bootstrapContainer{Index: i, AccountsFile: accountsFile(i)}
+	}
+
+	var bootstrapScript bytes.Buffer
+	err = bootstrapTmpl.Execute(&bootstrapScript, map[string]interface{}{
+		"MarkerDir":      markerDir,
+		"ReadyMarker":    readyMarker,
+		"FailedMarker":   failedMarker,
+		"WaitFunc":       waitFunc,
+		"RegionCmd":      regionCmd,
+		"PullAgent":      pullCmd("agent", images.Agent),
+		"PullRunner":     pullCmd("runner", images.Runner),
+		"AgentEnvFile":   agentEnvFile,
+		"AgentCmd":       agentCmd(runID, images.Agent),
+		"WaitAgent":      agentProbe.waitCmd(),
+		"ScreenshotPath": screenshotPathHost,
+		"StepEnvFile":    stepEnvFile,
+		"Containers":     bootstrapContainers,
+		"RunnerPath":     runnerPath,
+	})
+	if err != nil {
+		return "", err
+	}
+
+	conf := cloudConfig{
+		WriteFiles: []cloudFile{
+			{Path: runnerPath, Permissions: "0700", Content: runnerScript.String()},
+			{Path: bootstrapPath, Permissions: "0700", Content: bootstrapScript.String()},
+		},
+		RunCmd: []string{fmt.Sprintf("%s > %s 2>&1", bootstrapPath, bootstrapLog)},
+	}
+	b, err := yaml.Marshal(conf)
+	if err != nil {
+		return "", err
+	}
+
+	return "#cloud-config\n" + string(b), nil
+}
+
+// containerName returns the name of the i-th runner container on an instance.
+func containerName(i int) string {
+	return fmt.Sprintf("runner-%d", i)
+}
+
+// accountsFile returns where the accounts of the i-th runner container are uploaded to.
+func accountsFile(i int) string {
+	return fmt.Sprintf("%s/%d/accounts.json", stepDir, i)
+}
@@ -0,0 +1,202 @@
+package runner
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// Images are the container images a remote runner is deployed with.
+type Images struct {
+	Runner string `json:"runner"`
+	Agent  string `json:"agent"`
+}
+
+// DefaultImages are used unless others are configured.
+var DefaultImages = Images{
+	Runner: "jsteinmann/load-tests-runner:latest",
+	Agent:  "datadog/agent:latest",
+}
+
+// Resolve pins all images to the digest their tags currently point to, so
+// that all runners of a run use the identical build. Images which already
+// reference a digest are kept as they are.
+func (imgs Images) Resolve(ctx context.Context) (Images, error) {
+	var err error
+	var resolved Images
+	if resolved.Runner, err = resolveDigest(ctx, imgs.Runner); err != nil {
+		return Images{}, fmt.Errorf("failed to resolve runner image %s: %w", imgs.Runner, err)
+	}
+	if resolved.Agent, err = resolveDigest(ctx, imgs.Agent); err != nil {
+		return Images{}, fmt.Errorf("failed to resolve agent image %s: %w", imgs.Agent, err)
+	}
+
+	return resolved, nil
+}
+
+// reference is a parsed image reference like registry/repository:tag@digest.
+type reference struct {
+	registry   string
+	repository string
+	tag        string
+	digest     string
+}
+
+const dockerHub = "registry-1.docker.io"
+
+func parseReference(image string) reference {
+	var ref reference
+	if i := strings.Index(image, "@"); i >= 0 {
+		image, ref.digest = image[:i], image[i+1:]
+	}
+	if i := strings.LastIndex(image, ":"); i > strings.LastIndex(image, "/") {
+		image, ref.tag = image[:i], image[i+1:]
+	}
+	if ref.tag == "" {
+		ref.tag = "latest"
+	}
+
+	parts := strings.SplitN(image, "/", 2)
+	if len(parts) == 2 && (strings.ContainsAny(parts[0], ".:") || parts[0] == "localhost") {
+		ref.registry, ref.repository = parts[0], parts[1]
+	} else {
+		ref.registry, ref.repository = dockerHub, image
+		if len(parts) == 1 {
+			ref.repository = "library/" + image
+		}
+	}
+
+	return ref
+}
+
+// name returns the reference without tag and digest, as used by docker.
+func (r reference) name() string {
+	if r.registry == dockerHub {
+		return strings.TrimPrefix(r.repository, "library/")
+	}
+	return r.registry + "/" + r.repository
+}
+
+// manifestTypes are accepted when resolving a tag. Multi-arch images resolve
+// to their manifest list, which is what docker records after a pull.
+var manifestTypes = []string{
+	"application/vnd.docker.distribution.manifest.list.v2+json",
+	"application/vnd.docker.distribution.manifest.v2+json",
+	"application/vnd.oci.image.index.v1+json",
+	"application/vnd.oci.image.manifest.v1+json",
+}
+
+func resolveDigest(ctx context.Context, image string) (string, error) {
+	ref := parseReference(image)
+	if ref.digest != "" {
+		return image, nil
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, 30*time.Second)
+	defer cancel()
+
+	u := fmt.Sprintf("https://%s/v2/%s/manifests/%s", ref.registry, ref.repository, ref.tag)
+	resp, err := manifestHead(ctx, u, "")
+	if err != nil {
+		return "", err
+	}
+	resp.Body.Close()
+
+	if resp.StatusCode == http.StatusUnauthorized {
+		token, err := fetchToken(ctx, resp.Header.Get("Www-Authenticate"))
+		if err != nil {
+			return "", err
+		}
+		if resp, err = manifestHead(ctx, u, token); err != nil {
+			return "", err
+		}
+		resp.Body.Close()
+	}
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("registry responded with %s", resp.Status)
+	}
+
+	digest := resp.Header.Get("Docker-Content-Digest")
+	if digest == "" {
+		return "", fmt.Errorf("registry didn't return a digest")
+	}
+
+	return ref.name() + "@" + digest, nil
+}
+
+func manifestHead(ctx context.Context, url, token string) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodHead, url, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", strings.Join(manifestTypes, ", "))
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+
+	return http.DefaultClient.Do(req)
+}
+
+// fetchToken requests an anonymous pull token as challenged by the registry.
+func fetchToken(ctx context.Context, challenge string) (string, error) {
+	if !strings.HasPrefix(challenge, "Bearer ") {
+		return "", fmt.Errorf("unsupported authentication challenge %q", challenge)
+	}
+
+	params := make(map[string]string)
+	for _, p := range strings.Split(strings.TrimPrefix(challenge, "Bearer "), ",") {
+		kv := strings.SplitN(p, "=", 2)
+		if len(kv) == 2 {
+			params[strings.TrimSpace(kv[0])] = strings.Trim(kv[1], `"`)
+		}
+	}
+
+	u, err := url.Parse(params["realm"])
+	if err != nil || params["realm"] == "" {
+		return "", fmt.Errorf("invalid authentication realm %q", params["realm"])
+	}
+	q := u.Query()
+	q.Set("service", params["service"])
+	q.Set("scope", params["scope"])
+	u.RawQuery = q.Encode()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u.String(), nil)
+	if err != nil {
+		return "", err
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("token request failed with %s", resp.Status)
+	}
+
+	var body struct {
+		Token       string `json:"token"`
+		AccessToken string `json:"access_token"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		return "", err
+	}
+	if body.Token != "" {
+		return body.Token, nil
+	}
+	return body.AccessToken, nil
+}
+
+// pullCmd returns a shell command which pulls the image and, if it has been
+// resolved, verifies that its digest matches. It fails the bootstrap otherwise.
+func pullCmd(name, image string) string {
+	check := "docker pull " + image + " > /dev/null"
+	if ref := parseReference(image); ref.digest != "" {
+		check += fmt.Sprintf(` && docker image inspect --format "{{range .RepoDigests}}{{println .}}{{end}}" %s | grep -qxF %s`, image, ref.name()+"@"+ref.digest)
+	}
+
+	return fmt.Sprintf(`{ %s; } || fail "%s image %s couldn't be pulled and verified"`, check, name, image)
+}
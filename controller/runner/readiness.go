@@ -0,0 +1,72 @@
+package runner
+
+import (
+	"fmt"
+	"time"
+)
+
+// probe checks whether a component on the runner's instance is ready. The
+// check is a shell command, which exits with 0 once the component is ready,
+// with 1 while it is still starting and with any other code if it won't
+// become ready anymore.
+type probe struct {
+	name    string
+	check   string
+	timeout time.Duration
+}
+
+// readyFileImage is written by the loadrunner once it has started all pages.
+const readyFileImage = "/tmp/ready"
+
+var (
+	// agentProbe waits for the agent's health check, which includes DogStatsD.
+	agentProbe = probe{
+		name:    "agent",
+		check:   containerCheck("dd-agent", "agent health > /dev/null"),
+		timeout: 3 * time.Minute,
+	}
+)
+
+// runnerProbe waits for the loadrunner in the container to write its ready
+// file. Starting a browser page per user takes a while for big steps.
+func runnerProbe(container string) probe {
+	return probe{
+		name:    container,
+		check:   containerCheck(container, "test -f "+readyFileImage),
+		timeout: 10 * time.Minute,
+	}
+}
+
+// containerCheck returns a check which runs cmd in the container and fails
+// right away if the container is not running anymore.
+func containerCheck(container, cmd string) string {
+	return fmt.Sprintf(`[ "$(docker inspect --format "{{.State.Running}}" %s)" = true ] || exit 2; docker exec %s %s || exit 1`, container, container, cmd)
+}
+
+// waitCmd returns a shell command which waits until the probe succeeds. If
+// it times out or fails, it prints the reason and returns 1. The check must
+// not contain single quotes, it may use the variables of the calling script.
+func (p probe) waitCmd() string {
+	return fmt.Sprintf("wait_for %s %d '%s'", p.name, int(p.timeout.Seconds()), p.check)
+}
+
+// waitFunc defines the wait_for shell function used by waitCmd.
+const waitFunc = `wait_for() {
+	deadline=$(( $(date +%s) + $2 ))
+	while :; do
+		code=0
+		(eval "$3") > /dev/null 2>&1 || code=$?
+		if [ $code -eq 0 ]; then
+			return 0
+		fi
+		if [ $code -ne 1 ]; then
+			echo "$1 failed"
+			return 1
+		fi
+		if [ $(date +%s) -ge $deadline ]; then
+			echo "$1 not ready after $2s"
+			return 1
+		fi
+		sleep 2
+	done
+}`
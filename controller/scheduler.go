@@ -0,0 +1,324 @@
+package controller
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+	"sync"
+
+	"github.com/DerGut/load-tests/accounts"
+	"github.com/DerGut/load-tests/controller/provisioner"
+	"github.com/DerGut/load-tests/controller/runner"
+)
+
+// Costs estimate the resources a virtual user needs on a runner. If neither
+// memory nor CPUs are given, runners are filled by their number of classes.
+type Costs struct {
+	VuMemoryMB float64
+	VuCpus     float64
+	// UnpreparedFactor scales the costs of unprepared classes, whose users
+	// sign up and set up the class first. Zero means the same cost as
+	// prepared classes.
+	UnpreparedFactor float64
+}
+
+func (c Costs) enabled() bool {
+	return c.VuMemoryMB > 0 || c.VuCpus > 0
+}
+
+// of returns the resources a class needs.
+func (c Costs) of(class accounts.Classroom) resources {
+	if !c.enabled() {
+		return resources{classes: 1}
+	}
+
+	factor := 1.0
+	if !class.Prepared && c.UnpreparedFactor > 0 {
+		factor = c.UnpreparedFactor
+	}
+	vus := float64(len(class.Pupils) + 1) // pupils and teacher
+	return resources{
+		memoryMB: vus * c.VuMemoryMB * factor,
+		cpus:     vus * c.VuCpus * factor,
+		classes:  1,
+	}
+}
+
+// resources are either needed by a class or available in a runner container.
+type resources struct {
+	memoryMB float64
+	cpus     float64
+	classes  int
+}
+
+func (r resources) fits(in resources) bool {
+	return r.memoryMB <= in.memoryMB && r.cpus <= in.cpus && r.classes <= in.classes
+}
+
+func (r resources) sub(o resources) resources {
+	return resources{r.memoryMB - o.memoryMB, r.cpus - o.cpus, r.classes - o.classes}
+}
+
+func (r resources) add(o resources) resources {
+	return resources{r.memoryMB + o.memoryMB, r.cpus + o.cpus, r.classes + o.classes}
+}
+
+// scheduledContainer is a runner container and the classes placed on it.
+type scheduledContainer struct {
+	free    resources
+	started bool
+	// pending holds the classes placed while scheduling a step.
+	pending []accounts.Classroom
+}
+
+// scheduledRunner is a runner instance and its containers.
+type scheduledRunner struct {
+	client runner.Client
+	// running is set once the runner has been started, from then on its
+	// spare containers can be filled.
+	running    bool
+	containers []*scheduledContainer
+}
+
+// scheduler places the classes of each step onto runners. Spare capacity
+// of running runners is filled first, only then new runners are started.
+type scheduler struct {
+	sync.Mutex
+	costs    Costs
+	capacity []resources
+	runners  []*scheduledRunner
+	// placements are in the order the classes were placed, so that load
+	// decreases remove the most recent classes first.
+	placements []placement
+}
+
+// placement records the container a class was placed on.
+type placement struct {
+	runner    *scheduledRunner
+	container int
+	class     accounts.Classroom
+	cost      resources
+}
+
+// stepSchedule is the placement of the classes of a step.
+type stepSchedule struct {
+	// extensions are containers to start on running runners.
+	extensions []extension
+	// runners are to be started with the classes of each of their containers.
+	runners []newRunner
+}
+
+type newRunner struct {
+	*scheduledRunner
+	classes [][]accounts.Classroom
+}
+
+// extension adds classes to a container of a running runner. The container
+// is started with them unless it is running already.
+type extension struct {
+	runner    *scheduledRunner
+	container int
+	classes   []accounts.Classroom
+	running   bool
+}
+
+// removal stops classes in a container of a running runner.
+type removal struct {
+	runner    *scheduledRunner
+	container int
+	classes   []accounts.Classroom
+}
+
+// newScheduler returns a scheduler for runners of the given size. The size
+// is only needed if the capacity is modeled by costs.
+func newScheduler(capacity Capacity, size provisioner.Size) (*scheduler, error) {
+	n := capacity.ContainersPerRunner
+	s := &scheduler{costs: capacity.Costs, capacity: make([]resources, n)}
+
+	if capacity.Costs.enabled() {
+		memoryMB, cpus := runner.ContainerShare(size, n)
+		if memoryMB <= 0 || cpus <= 0 {
+			return nil, fmt.Errorf("instance size %s is too small for %d runner containers", size.Slug, n)
+		}
+		for i := range s.capacity {
+			s.capacity[i] = resources{memoryMB: memoryMB, cpus: cpus, classes: math.MaxInt32}
+		}
+		return s, nil
+	}
+
+	// Without costs, the classes of a runner are spread evenly across its containers
+	for i := range s.capacity {
+		classes := capacity.ClassesPerRunner / n
+		if i < capacity.ClassesPerRunner%n {
+			classes++
+		}
+		s.capacity[i] = resources{memoryMB: math.Inf(1), cpus: math.Inf(1), classes: classes}
+	}
+	return s, nil
+}
+
+var errClassTooBig = errors.New("class exceeds the capacity of a runner container")
+
+// schedule places the classes using first-fit decreasing. Running runners
+// come first, then runners which are new in this step.
+func (s *scheduler) schedule(classes []accounts.Classroom) (*stepSchedule, error) {
+	s.Lock()
+	defer s.Unlock()
+
+	ordered := make([]accounts.Classroom, len(classes))
+	copy(ordered, classes)
+	sort.SliceStable(ordered, func(i, j int) bool {
+		return s.weight(ordered[i]) > s.weight(ordered[j])
+	})
+
+	var candidates []*scheduledRunner
+	for _, r := range s.runners {
+		if r.running {
+			candidates = append(candidates, r)
+		}
+	}
+	existing := len(candidates)
+
+	for _, class := range ordered {
+		cost := s.costs.of(class)
+		if !s.place(candidates, class, cost) {
+			r := s.newRunner()
+			if !s.place([]*scheduledRunner{r}, class, cost) {
+				return nil, fmt.Errorf("%w: %s with %d pupils", errClassTooBig, class.Name, len(class.Pupils))
+			}
+			candidates = append(candidates, r)
+		}
+	}
+
+	// Containers are started with their classes right away, so that the
+	// schedules of overlapping steps don't place onto them anymore.
+	var sched stepSchedule
+	for i, r := range candidates {
+		if i >= existing {
+			s.runners = append(s.runners, r)
+			sched.runners = append(sched.runners, newRunner{r, r.take()})
+			continue
+		}
+		for j, c := range r.containers {
+			if len(c.pending) > 0 {
+				sched.extensions = append(sched.extensions, extension{r, j, c.pending, c.started})
+			}
+		}
+		r.take()
+	}
+
+	return &sched, nil
+}
+
+// take returns the classes placed on each container and marks containers
+// with classes as started.
+func (r *scheduledRunner) take() [][]accounts.Classroom {
+	classes := make([][]accounts.Classroom, len(r.containers))
+	for i, c := range r.containers {
+		classes[i] = c.pending
+		c.started = c.started || len(c.pending) > 0
+		c.pending = nil
+	}
+	return classes
+}
+
+// weight returns the share of a container the class needs, by the scarcest resource.
+func (s *scheduler) weight(class accounts.Classroom) float64 {
+	cost, capacity := s.costs.of(class), s.capacity[0]
+	return math.Max(cost.memoryMB/capacity.memoryMB, math.Max(cost.cpus/capacity.cpus, float64(cost.classes)/float64(capacity.classes)))
+}
+
+// place puts the class into the first container with enough free resources.
+func (s *scheduler) place(runners []*scheduledRunner, class accounts.Classroom, cost resources) bool {
+	for _, r := range runners {
+		for i, c := range r.containers {
+			if !cost.fits(c.free) {
+				continue
+			}
+			c.free = c.free.sub(cost)
+			c.pending = append(c.pending, class)
+			s.placements = append(s.placements, placement{r, i, class, cost})
+			return true
+		}
+	}
+	return false
+}
+
+// release frees the n most recently placed classes of running runners and
+// returns them by container. Classes of runners which are still starting
+// can't be removed yet, so fewer classes may be released.
+func (s *scheduler) release(n int) []removal {
+	s.Lock()
+	defer s.Unlock()
+
+	var removals []removal
+	released := make([]bool, len(s.placements))
+	for i := len(s.placements) - 1; i >= 0 && n > 0; i-- {
+		p := s.placements[i]
+		if !p.runner.running {
+			continue
+		}
+		n--
+
+		c := p.runner.containers[p.container]
+		c.free = c.free.add(p.cost)
+		removals = addRemoval(removals, p)
+		released[i] = true
+	}
+
+	var kept []placement
+	for i, p := range s.placements {
+		if !released[i] {
+			kept = append(kept, p)
+		}
+	}
+	s.placements = kept
+
+	return removals
+}
+
+func addRemoval(removals []removal, p placement) []removal {
+	for i, r := range removals {
+		if r.runner == p.runner && r.container == p.container {
+			removals[i].classes = append(removals[i].classes, p.class)
+			return removals
+		}
+	}
+	return append(removals, removal{p.runner, p.container, []accounts.Classroom{p.class}})
+}
+
+func (s *scheduler) newRunner() *scheduledRunner {
+	r := &scheduledRunner{containers: make([]*scheduledContainer, len(s.capacity))}
+	for i := range r.containers {
+		r.containers[i] = &scheduledContainer{free: s.capacity[i]}
+	}
+	return r
+}
+
+// running marks the new runners of the schedule as running, so that their
+// spare containers are filled in later steps. Runners which failed to start
+// are forgotten.
+func (s *scheduler) running(sched *stepSchedule, failed ...*scheduledRunner) {
+	s.Lock()
+	defer s.Unlock()
+
+	for _, f := range failed {
+		for i, r := range s.runners {
+			if r == f {
+				s.runners = append(s.runners[:i], s.runners[i+1:]...)
+				break
+			}
+		}
+		kept := s.placements[:0]
+		for _, p := range s.placements {
+			if p.runner != f {
+				kept = append(kept, p)
+			}
+		}
+		s.placements = kept
+	}
+	for _, r := range sched.runners {
+		r.running = true
+	}
+}
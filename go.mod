@@ -3,8 +3,12 @@ module github.com/DerGut/load-tests
 go 1.15
 
 require (
+	github.com/BurntSushi/toml v0.3.1
 	github.com/digitalocean/doctl v1.58.0
 	github.com/digitalocean/godo v1.59.0
+	go.mongodb.org/mongo-driver v1.5.1
 	golang.org/x/crypto v0.0.0-20201216223049-8b5274cf687f
 	golang.org/x/mod v0.4.2
+	golang.org/x/time v0.0.0-20210220033141-f8bda1e9f3ba
+	gopkg.in/yaml.v2 v2.4.0
 )
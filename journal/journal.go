@@ -0,0 +1,306 @@
+// Package journal records what happens during a load test run, so that it
+// can be reported on and cleaned up after, even if loadctl crashed.
+package journal
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/DerGut/load-tests/secrets"
+)
+
+// DefaultDir is the directory the journals of all runs are stored in.
+const DefaultDir = ".loadctl/runs"
+
+var ErrNoRuns = errors.New("no runs recorded")
+
+// Run is the journal of a single run. It is saved after every change.
+// All methods can be called on a nil *Run, in which case nothing is recorded.
+type Run struct {
+	mu   sync.Mutex
+	path string
+
+	ID     string          `json:"id"`
+	Start  time.Time       `json:"start"`
+	End    *time.Time      `json:"end,omitempty"`
+	Config json.RawMessage `json:"config,omitempty"`
+	Steps  []Step          `json:"steps"`
+	Error  string          `json:"error,omitempty"`
+	// Images maps the components of a runner to the image they ran, pinned by digest.
+	Images map[string]string `json:"images,omitempty"`
+	// Regions maps each runner to the region of its instance.
+	Regions map[string]string `json:"regions,omitempty"`
+
+	// ProjectedSpend and ActualSpend are given in USD.
+	ProjectedSpend float64 `json:"projectedSpend,omitempty"`
+	ActualSpend    float64 `json:"actualSpend,omitempty"`
+
+	// Submitted maps each pupil to what the runners reported they submitted.
+	Submitted map[string]Submissions `json:"submitted,omitempty"`
+	// Reconciliation compares Submitted with the database after the run.
+	Reconciliation *Reconciliation `json:"reconciliation,omitempty"`
+}
+
+// Submissions counts what a pupil has submitted.
+type Submissions struct {
+	Exercises  int `json:"exercises"`
+	TaskSeries int `json:"taskSeries"`
+}
+
+// Add returns the sum of both submissions.
+func (s Submissions) Add(other Submissions) Submissions {
+	return Submissions{Exercises: s.Exercises + other.Exercises, TaskSeries: s.TaskSeries + other.TaskSeries}
+}
+
+// Reconciliation compares what the runners reported to have submitted with
+// what the test accounts produced in the database of the system under test.
+type Reconciliation struct {
+	Reported Submissions `json:"reported"`
+	Stored   Submissions `json:"stored"`
+	// ClassLogs is the number of class log entries of the test classes.
+	ClassLogs int `json:"classLogs"`
+	// LostWrites lists the pupils of whom the database holds fewer
+	// submissions than the runners reported.
+	LostWrites []LostWrite `json:"lostWrites,omitempty"`
+	// Error is set if the database couldn't be queried.
+	Error string `json:"error,omitempty"`
+}
+
+type LostWrite struct {
+	Pupil    string      `json:"pupil"`
+	Reported Submissions `json:"reported"`
+	Stored   Submissions `json:"stored"`
+}
+
+type Step struct {
+	Start   time.Time `json:"start"`
+	Load    int       `json:"load"`
+	Runners []string  `json:"runners,omitempty"`
+}
+
+// New creates and saves the journal for a new run. The config should
+// not contain any secrets.
+func New(dir, id string, config interface{}) (*Run, error) {
+	b, err := json.Marshal(config)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, err
+	}
+
+	r := &Run{
+		path:   filepath.Join(dir, id+".json"),
+		ID:     id,
+		Start:  time.Now(),
+		Config: b,
+	}
+	if err := r.save(); err != nil {
+		return nil, err
+	}
+
+	return r, nil
+}
+
+// AddStep records the start of a new step and returns its index.
+func (r *Run) AddStep(load int) int {
+	if r == nil {
+		return 0
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.Steps = append(r.Steps, Step{Start: time.Now(), Load: load})
+	r.saveOrLog()
+	return len(r.Steps) - 1
+}
+
+// AddRunners records runners which have been started during the given step.
+func (r *Run) AddRunners(step int, runners ...string) {
+	if r == nil {
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.Steps[step].Runners = append(r.Steps[step].Runners, runners...)
+	r.saveOrLog()
+}
+
+// SetRegion records the region a runner has been deployed to.
+func (r *Run) SetRegion(runner, region string) {
+	if r == nil || region == "" {
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.Regions == nil {
+		r.Regions = make(map[string]string)
+	}
+	r.Regions[runner] = region
+	r.saveOrLog()
+}
+
+// SetImages records the images all runners have been deployed with.
+func (r *Run) SetImages(images map[string]string) {
+	if r == nil {
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.Images = images
+	r.saveOrLog()
+}
+
+// SetSpend records the projected and actual cost of all instances.
+func (r *Run) SetSpend(projected, actual float64) {
+	if r == nil {
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.ProjectedSpend = projected
+	r.ActualSpend = actual
+	r.saveOrLog()
+}
+
+// AddSubmitted records what the pupils of a runner have submitted.
+func (r *Run) AddSubmitted(submitted map[string]Submissions) {
+	if r == nil {
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.Submitted == nil {
+		r.Submitted = make(map[string]Submissions)
+	}
+	for pupil, s := range submitted {
+		r.Submitted[pupil] = r.Submitted[pupil].Add(s)
+	}
+	r.saveOrLog()
+}
+
+// SetReconciliation records the reconciliation of the submissions with the database.
+func (r *Run) SetReconciliation(rec Reconciliation) {
+	if r == nil {
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.Reconciliation = &rec
+	r.saveOrLog()
+}
+
+// Finish records the end of the run together with the error it failed with.
+func (r *Run) Finish(runErr error) error {
+	if r == nil {
+		return nil
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	now := time.Now()
+	r.End = &now
+	if runErr != nil {
+		r.Error = secrets.Redact(runErr.Error())
+	}
+	return r.save()
+}
+
+// Finished returns whether the run has ended.
+func (r *Run) Finished() bool {
+	return r.End != nil
+}
+
+func (r *Run) saveOrLog() {
+	if err := r.save(); err != nil {
+		log.Println("Failed to save journal:", err)
+	}
+}
+
+func (r *Run) save() error {
+	b, err := json.MarshalIndent(r, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	// Write to a temporary file first to never leave a corrupt journal behind
+	tmp := r.path + ".tmp"
+	if err := ioutil.WriteFile(tmp, b, 0o644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, r.path)
+}
+
+// Load reads the journal of the run with the given ID.
+func Load(dir, id string) (*Run, error) {
+	path := filepath.Join(dir, id+".json")
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	r := &Run{path: path}
+	if err := json.Unmarshal(b, r); err != nil {
+		return nil, fmt.Errorf("corrupt journal %s: %w", path, err)
+	}
+
+	return r, nil
+}
+
+// List reads the journals of all runs, ordered by their start.
+func List(dir string) ([]*Run, error) {
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	var runs []*Run
+	for _, f := range files {
+		if f.IsDir() || filepath.Ext(f.Name()) != ".json" {
+			continue
+		}
+		r, err := Load(dir, strings.TrimSuffix(f.Name(), ".json"))
+		if err != nil {
+			return nil, err
+		}
+		runs = append(runs, r)
+	}
+
+	sort.Slice(runs, func(i, j int) bool {
+		return runs[i].Start.Before(runs[j].Start)
+	})
+
+	return runs, nil
+}
+
+// Latest reads the journal of the most recently started run.
+func Latest(dir string) (*Run, error) {
+	runs, err := List(dir)
+	if err != nil {
+		return nil, err
+	}
+	if len(runs) == 0 {
+		return nil, ErrNoRuns
+	}
+
+	return runs[len(runs)-1], nil
+}
@@ -0,0 +1,63 @@
+import http from "http";
+
+import newLogger from "./logger";
+import { PageProvider } from "./PageProvider";
+import LoadRunner from "./runner";
+import { parseClassrooms } from "./vus/accounts";
+import { allSubmitted } from "./submitted";
+
+const logger = newLogger("control");
+
+// serveControl lets the controller change the classes of a running runner:
+//   GET  /classes         lists the teachers of all running classes
+//   POST /classes         starts the JSON encoded classes, once their pages have been started
+//   POST /classes/remove  stops the classes of the JSON encoded list of teachers, once their VUs have stopped
+//   GET  /submitted       lists the exercises and task series each pupil has submitted
+export default function serveControl(port: number, runner: LoadRunner, provider: PageProvider): http.Server {
+    const server = http.createServer(async (req, res) => {
+        try {
+            if (req.method === "GET" && req.url === "/classes") {
+                respond(res, 200, runner.accounts.map(c => c.teacher.id()));
+            } else if (req.method === "POST" && req.url === "/classes") {
+                const classrooms = parseClassrooms(await readBody(req));
+                logger.info(`Adding ${classrooms.length} classes`);
+                const pages = await provider.provideFromBrowsers(classrooms);
+                runner.addClasses(classrooms, pages).catch(e => logger.error("Failed adding classes", e));
+                respond(res, 200, { classes: classrooms.length });
+            } else if (req.method === "POST" && req.url === "/classes/remove") {
+                const teachers: string[] = JSON.parse(await readBody(req));
+                logger.info(`Removing ${teachers.length} classes`);
+                const vus = await runner.removeClasses(teachers);
+                respond(res, 200, { vus });
+            } else if (req.method === "GET" && req.url === "/submitted") {
+                respond(res, 200, allSubmitted());
+            } else {
+                respond(res, 404, { error: "not found" });
+            }
+        } catch (e) {
+            if (e instanceof SyntaxError) {
+                respond(res, 400, { error: e.message });
+            } else {
+                logger.error("Failed handling control request", e);
+                respond(res, 500, { error: String(e) });
+            }
+        }
+    });
+    server.listen(port, () => logger.info(`Control channel listening on port ${port}`));
+
+    return server;
+}
+
+function readBody(req: http.IncomingMessage): Promise<string> {
+    return new Promise((resolve, reject) => {
+        const chunks: Buffer[] = [];
+        req.on("data", chunk => chunks.push(chunk));
+        req.on("end", () => resolve(Buffer.concat(chunks).toString()));
+        req.on("error", reject);
+    });
+}
+
+function respond(res: http.ServerResponse, status: number, body: unknown) {
+    res.writeHead(status, { "Content-Type": "application/json" });
+    res.end(JSON.stringify(body));
+}
@@ -5,11 +5,12 @@ import { BrowserContextOptions, chromium, LaunchOptions, Logger as PWLogger } fr
 
 import newLogger, { root as rootLogger } from "./logger";
 import statsd, { CLASSES, RUNNERS } from "./statsd";
-import LoadRunner, { PageMap } from "./runner";
+import LoadRunner from "./runner";
 import fs from "fs/promises";
 import { PageProvider } from "./PageProvider";
 import { Logger } from "winston";
-import { Account, Classroom, Pupil, Teacher } from "./vus/accounts";
+import { Account, Classroom, parseClassrooms } from "./vus/accounts";
+import serveControl from "./control";
 
 (async () => {
     SegfaultHandler.registerHandler();
@@ -22,22 +23,36 @@ import { Account, Classroom, Pupil, Teacher } from "./vus/accounts";
         rootLogger.info("Not taking screenshot");
     }
 
-    const pages = await startPages(headless, accounts);
+    const provider = newPageProvider(headless);
+    const pages = await provider.provideFromBrowsers(accounts);
     rootLogger.info(`Started all ${pages.size} pages`);
 
     const runner = new LoadRunner(pages, runID, url, accounts, screenshotPath);
+    if (process.env.CONTROL_PORT) {
+        serveControl(parseInt(process.env.CONTROL_PORT), runner, provider);
+    }
+    await markReady();
+
     runner.on("stopped", async () => {
         rootLogger.info("Runner has stopped.");
         statsd.decrement(RUNNERS);
-        statsd.decrement(CLASSES, accounts.length);
+        statsd.decrement(CLASSES, runner.accounts.length);
     });
     handleSignals(runner);
 
     statsd.increment(RUNNERS);
     await runner.start();
-    rootLogger.info(`Started all ${accounts.length * (accounts[0].pupils.length + 1)} users`);
+    rootLogger.info(`Started all ${runner.vus.length} users`);
 })();
 
+// markReady signals the controller that the runner is up by writing READY_FILE, if given.
+async function markReady() {
+    const readyFile = process.env.READY_FILE;
+    if (readyFile) {
+        await fs.writeFile(readyFile, new Date().toISOString());
+    }
+}
+
 type ConfigType = { runID: string, url: string, accounts: Classroom[], screenshotPath: string, headless: boolean };
 
 async function parseArgs(args: string[]): Promise<ConfigType> {
@@ -83,17 +98,8 @@ async function parseArgs(args: string[]): Promise<ConfigType> {
 }
 
 function parseAccounts(json: string): Classroom[] {
-    let accounts: Classroom[] = [];
     try {
-        const parsed = JSON.parse(json);
-        for (let i = 0; i < parsed.length; i++) {
-            const classroom = parsed[i];
-            
-            const teacher = new Teacher(classroom.teacher);
-            const pupils = pupilsFromJson(classroom.pupils);
-
-            accounts.push(Object.assign(classroom, { teacher, pupils }));
-        }
+        return parseClassrooms(json);
     } catch (e) {
         if (e instanceof SyntaxError) {
             rootLogger.error("Error parsing accounts", e);
@@ -101,16 +107,6 @@ function parseAccounts(json: string): Classroom[] {
         }
         throw e;
     }
-
-    return accounts;
-}
-
-function pupilsFromJson(json: []): Pupil[] {
-    const pupils = [];
-    for (let i = 0; i < json.length; i++) {
-        pupils.push(new Pupil(json[i]));
-    }
-    return pupils;
 }
 
 const browserOptions: LaunchOptions = { 
@@ -124,7 +120,7 @@ const browserOptions: LaunchOptions = {
     handleSIGTERM: false
 };
 
-async function startPages(headless: boolean, accounts: Classroom[]): Promise<PageMap> {
+function newPageProvider(headless: boolean): PageProvider {
     Object.assign(browserOptions, { headless });
     
     const contextOptionsProvider = (account: Account): BrowserContextOptions => {
@@ -132,8 +128,7 @@ async function startPages(headless: boolean, accounts: Classroom[]): Promise<Pag
         return { logger: newPlaywrightLogger(logger) };
     };
 
-    const provider = new PageProvider(chromium, browserOptions, contextOptionsProvider);
-    return provider.provideFromBrowsers(accounts);
+    return new PageProvider(chromium, browserOptions, contextOptionsProvider);
 }
 
 function newPlaywrightLogger(logger: Logger): PWLogger {
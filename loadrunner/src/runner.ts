@@ -20,6 +20,8 @@ export default class LoadRunner extends EventEmitter {
     screenshotPath: string;
 
     vus: VirtualUser[] = [];
+    // classVUs holds the VUs of each class by the id of its teacher
+    classVUs: Map<string, VirtualUser[]> = new Map();
     constructor(pages: PageMap, runID: string, url: string, accounts: Classroom[], screenshotPath: string) {
         super();
         this.pages = pages;
@@ -33,22 +35,60 @@ export default class LoadRunner extends EventEmitter {
         this.logger.info("Starting up");
 
         for (let i = 0; i < this.accounts.length; i++) {
-            const classroom = this.accounts[i];
-            statsd.increment(CLASSES);
-            if (classroom.prepared) {
-                this.logger.info("Starting prepared classroom");
-                const vus = await this.startPreparedClassroom(classroom)
-                this.vus.push(...vus);
-            } else {
-                this.logger.info("Starting new classroom");
-                const vus = await this.startNewClassroom(classroom);
-                this.vus.push(...vus);
-            }
+            await this.startClassroom(this.accounts[i]);
             await new Promise(resolve => setTimeout(resolve, 1 * 1000));
         }
     }
 
+    async startClassroom(classroom: Classroom) {
+        statsd.increment(CLASSES);
+        let vus: VirtualUser[];
+        if (classroom.prepared) {
+            this.logger.info("Starting prepared classroom");
+            vus = await this.startPreparedClassroom(classroom);
+        } else {
+            this.logger.info("Starting new classroom");
+            vus = await this.startNewClassroom(classroom);
+        }
+        this.vus.push(...vus);
+        this.classVUs.set(classroom.teacher.id(), vus);
+    }
+
+    // addClasses starts additional classes, for which the pages are given.
+    async addClasses(classrooms: Classroom[], pages: PageMap) {
+        pages.forEach((page, id) => this.pages.set(id, page));
+        this.accounts.push(...classrooms);
+        for (let i = 0; i < classrooms.length; i++) {
+            await this.startClassroom(classrooms[i]);
+            await new Promise(resolve => setTimeout(resolve, 1 * 1000));
+        }
+    }
+
+    // removeClasses stops the classes of the given teachers and resolves once all of their VUs have stopped.
+    async removeClasses(teacherIds: string[]): Promise<number> {
+        const stopped: Promise<void>[] = [];
+        for (const id of teacherIds) {
+            const vus = this.classVUs.get(id);
+            if (!vus) {
+                continue;
+            }
+            this.classVUs.delete(id);
+            this.accounts = this.accounts.filter(c => c.teacher.id() !== id);
+            statsd.decrement(CLASSES);
+            for (const vu of vus) {
+                stopped.push(new Promise(resolve => vu.once("stopped", () => resolve())));
+                vu.stop();
+            }
+        }
+        await Promise.all(stopped);
+        return stopped.length;
+    }
+
     async stop() {
+        if (this.vus.length === 0) {
+            this.emit("stopped");
+            return;
+        }
         let pending = this.vus.length;
         this.vus.forEach(vu => {
             vu.on("stopped", () => {
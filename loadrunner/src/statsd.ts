@@ -6,7 +6,9 @@ const statsdLogger = newLogger("statsd");
 export default new StatsD({
     prefix: "load-tests.",
     globalTags: {
-        "runId": process.env.RUN_ID
+        "runId": process.env.RUN_ID,
+        // Remote runners are tagged with the region of their instance
+        ...(process.env.REGION ? { "region": process.env.REGION } : {}),
     } as Tags,
     errorHandler: function name(error) {
         statsdLogger.warn(error);
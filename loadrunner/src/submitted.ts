@@ -0,0 +1,20 @@
+// Submissions counts what a pupil has submitted, in addition to the
+// submitted.* metrics, so that the controller can reconcile it with the
+// database of the system under test after a run.
+export interface Submissions {
+    exercises: number;
+    taskseries: number;
+}
+
+const submitted = new Map<string, Submissions>();
+
+export function recordSubmitted(pupil: string, kind: keyof Submissions) {
+    const s = submitted.get(pupil) || { exercises: 0, taskseries: 0 };
+    s[kind]++;
+    submitted.set(pupil, s);
+}
+
+// allSubmitted returns the submissions of all pupils by their username.
+export function allSubmitted(): { [pupil: string]: Submissions } {
+    return Object.fromEntries(submitted);
+}
@@ -37,3 +37,19 @@ export class Pupil implements Account {
         return this.username;
     }
 }
+
+// parseClassrooms parses JSON encoded classrooms. It throws a SyntaxError if the JSON is invalid.
+export function parseClassrooms(json: string): Classroom[] {
+    const classrooms: Classroom[] = [];
+    const parsed = JSON.parse(json);
+    for (let i = 0; i < parsed.length; i++) {
+        const classroom = parsed[i];
+
+        const teacher = new Teacher(classroom.teacher);
+        const pupils = (classroom.pupils as []).map(p => new Pupil(p));
+
+        classrooms.push(Object.assign(classroom, { teacher, pupils }));
+    }
+
+    return classrooms;
+}
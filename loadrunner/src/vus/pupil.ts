@@ -6,6 +6,7 @@ import statsd, { EXERCISES_SUBMITTED, TASKSERIES_SUBMITTED } from "../statsd";
 import { TaskSeries } from "./pageObjects/TaskSeries";
 import { Logger } from "winston";
 import { Pupil } from "./accounts";
+import { recordSubmitted } from "../submitted";
 
 export default class VirtualPupil extends VirtualUser {
     account: Pupil;
@@ -113,6 +114,7 @@ export default class VirtualPupil extends VirtualUser {
                     } while (!done);
                     this.logger.info("Submitted exercise");
                     statsd.increment(EXERCISES_SUBMITTED, this.tags);
+                    recordSubmitted(this.account.username, "exercises");
 
                     await this.think();
                 }
@@ -129,6 +131,7 @@ export default class VirtualPupil extends VirtualUser {
             
             await page.click("button:has-text('OK')"); // dismiss modal
             statsd.increment(TASKSERIES_SUBMITTED, this.tags);
+            recordSubmitted(this.account.username, "taskseries");
             if (await this.investmentAvailable(page)) {
                 await this.think();
                 await this.think();
@@ -0,0 +1,108 @@
+// Package secrets resolves secrets from files and env vars and redacts
+// them from everything loadctl writes.
+package secrets
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+)
+
+const (
+	// Mask replaces secrets in redacted output.
+	Mask = "********"
+
+	filePrefix = "file:"
+	envPrefix  = "env:"
+	// minLength keeps short values from redacting unrelated output.
+	minLength = 4
+)
+
+var (
+	mu         sync.RWMutex
+	known      = make(map[string]bool)
+	replacer   = strings.NewReplacer()
+	registered []string
+)
+
+// Resolve returns the secret a value refers to: the content of the file of
+// a file:<path> value without trailing newlines, the env var of an
+// env:<name> value, or otherwise the value itself. The secret is
+// registered, so that Redact removes it.
+func Resolve(val string) (string, error) {
+	secret := val
+	switch {
+	case strings.HasPrefix(val, filePrefix):
+		path := strings.TrimPrefix(val, filePrefix)
+		b, err := ioutil.ReadFile(path)
+		if err != nil {
+			return "", fmt.Errorf("couldn't read secret: %w", err)
+		}
+		secret = strings.TrimRight(string(b), "\r\n")
+	case strings.HasPrefix(val, envPrefix):
+		name := strings.TrimPrefix(val, envPrefix)
+		var ok bool
+		if secret, ok = os.LookupEnv(name); !ok {
+			return "", fmt.Errorf("secret env var %s is not set", name)
+		}
+	}
+
+	Register(secret)
+	return secret, nil
+}
+
+// Register makes Redact remove the secrets.
+func Register(secrets ...string) {
+	mu.Lock()
+	defer mu.Unlock()
+
+	added := false
+	for _, s := range secrets {
+		if len(s) >= minLength && !known[s] {
+			known[s] = true
+			registered = append(registered, s)
+			added = true
+		}
+	}
+	if !added {
+		return
+	}
+
+	// Longer secrets go first, in case one contains another
+	sort.Slice(registered, func(i, j int) bool {
+		return len(registered[i]) > len(registered[j])
+	})
+	pairs := make([]string, 0, 2*len(registered))
+	for _, s := range registered {
+		pairs = append(pairs, s, Mask)
+	}
+	replacer = strings.NewReplacer(pairs...)
+}
+
+// Redact replaces all registered secrets within s.
+func Redact(s string) string {
+	mu.RLock()
+	defer mu.RUnlock()
+	return replacer.Replace(s)
+}
+
+// Writer redacts all registered secrets from what is written to w. Secrets
+// are only found within a single write, which suffices for log output.
+func Writer(w io.Writer) io.Writer {
+	return redactingWriter{w}
+}
+
+type redactingWriter struct {
+	w io.Writer
+}
+
+func (rw redactingWriter) Write(p []byte) (int, error) {
+	if _, err := io.WriteString(rw.w, Redact(string(p))); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
@@ -2,6 +2,7 @@ package ssh
 
 import (
 	"fmt"
+	"io"
 	"net"
 	"os"
 
@@ -12,10 +13,12 @@ import (
 type Client struct {
 	addr   string
 	config *ssh.ClientConfig
+	// agent is the connection to the SSH agent, which Close closes.
+	agent net.Conn
 }
 
 func NewClient(username string, addr string) (*Client, error) {
-	auth, err := sshAgent()
+	conn, auth, err := sshAgent()
 	if err != nil {
 		return nil, err
 	}
@@ -28,11 +31,19 @@ func NewClient(username string, addr string) (*Client, error) {
 			},
 			HostKeyCallback: ssh.InsecureIgnoreHostKey(),
 		},
+		agent: conn,
 	}, nil
 }
 
+// Close closes the connection to the SSH agent. Sessions have to be closed on their own.
+func (c *Client) Close() error {
+	return c.agent.Close()
+}
+
+// Session is a session on a connection of its own, which Close closes as well.
 type Session struct {
 	*ssh.Session
+	closers []io.Closer
 }
 
 func (c *Client) Session() (*Session, error) {
@@ -42,20 +53,50 @@ func (c *Client) Session() (*Session, error) {
 	}
 
 	session, err := conn.NewSession()
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return &Session{Session: session, closers: []io.Closer{conn}}, nil
+}
+
+// Dial opens a session with its own client, which is closed together with the session.
+func Dial(username, addr string) (*Session, error) {
+	c, err := NewClient(username, addr)
 	if err != nil {
 		return nil, err
 	}
-	return &Session{Session: session}, nil
+
+	s, err := c.Session()
+	if err != nil {
+		c.Close()
+		return nil, err
+	}
+	s.closers = append(s.closers, c)
+	return s, nil
+}
+
+// Close closes the session and its connections. Errors of an already
+// closed session, e.g. after Run, are ignored.
+func (s *Session) Close() error {
+	s.Session.Close()
+	var err error
+	for _, c := range s.closers {
+		if cErr := c.Close(); cErr != nil && err == nil {
+			err = cErr
+		}
+	}
+	return err
 }
 
-func sshAgent() (ssh.AuthMethod, error) {
+func sshAgent() (net.Conn, ssh.AuthMethod, error) {
 	sshAgent, err := net.Dial("unix", os.Getenv("SSH_AUTH_SOCK"))
 	if err != nil {
-		return nil, fmt.Errorf("failed to dial SSH_AUTH_SOCKET %w", err)
+		return nil, nil, fmt.Errorf("failed to dial SSH_AUTH_SOCKET %w", err)
 	}
 
 	c := agent.NewClient(sshAgent)
 	auth := ssh.PublicKeysCallback(c.Signers)
 
-	return auth, nil
+	return sshAgent, auth, nil
 }